@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/client"
+	"github.com/jms-guy/httpfromtcp/internal/cookie"
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+)
+
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+func main() {
+	method := flag.String("X", "", "request method, GET by default or POST when -d is given")
+	data := flag.String("d", "", "request body, or @file to read it from a file")
+	verbose := flag.Bool("v", false, "show the bytes sent and received, chunk boundaries and trailers on stderr")
+	follow := flag.Bool("L", false, "follow redirects")
+	timeout := flag.Duration("timeout", 30*time.Second, "time allowed for the response head to arrive")
+	var extraHeaders headerFlags
+	flag.Var(&extraHeaders, "H", "request header as \"Name: value\", may be repeated to add more values")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: httpclient [flags] url\n\n"+
+			"The response is printed as parsed, not as received: fields are sorted\n"+
+			"by name with repeated ones other than Set-Cookie joined, and the body\n"+
+			"is decoded from its chunked framing. Use -v to see the bytes on the wire.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var body []byte
+	if strings.HasPrefix(*data, "@") {
+		fileBody, err := os.ReadFile(strings.TrimPrefix(*data, "@"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		body = fileBody
+	} else if *data != "" {
+		body = []byte(*data)
+	}
+	if *method == "" {
+		*method = "GET"
+		if body != nil {
+			*method = "POST"
+		}
+	}
+
+	req, err := client.NewRequest(*method, flag.Arg(0), body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The first -H for a field replaces any default NewRequest set, such as
+	// Host, and later ones for the same field add to it
+	given := map[string]bool{}
+	for _, header := range extraHeaders {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || !headers.IsToken(strings.TrimSpace(name)) {
+			log.Fatalf("error: malformed header %q", header)
+		}
+		key := strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if existing := req.Headers[key]; given[key] && existing != "" {
+			// Cookie pairs are separated with semicolons rather than commas
+			separator := ", "
+			if key == "cookie" {
+				separator = "; "
+			}
+			value = existing + separator + value
+		}
+		req.Headers[key] = value
+		given[key] = true
+	}
+
+	c := &client.Client{Timeout: *timeout, FollowRedirects: *follow, Jar: cookie.NewJar()}
+	var taps []*tapConn
+	if *verbose {
+		c.WrapConn = func(conn net.Conn) net.Conn {
+			fmt.Fprintf(os.Stderr, "* Connected to %s\n", conn.RemoteAddr())
+			tap := &tapConn{Conn: conn, out: os.Stderr}
+			taps = append(taps, tap)
+			return tap
+		}
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Close()
+	if *verbose {
+		resp.OnChunk = func(size int64) {
+			fmt.Fprintf(os.Stderr, "* Chunk of %d bytes\n", size)
+		}
+	}
+
+	// The parsed response is printed back out, the wire bytes only go to
+	// stderr with -v
+	fmt.Printf("HTTP/%s %d %s\r\n", resp.HttpVersion, resp.StatusCode, resp.Reason)
+	printFields(resp.Headers)
+	for _, value := range resp.SetCookies {
+		fmt.Printf("Set-Cookie: %s\r\n", value)
+	}
+	fmt.Print("\r\n")
+	_, err = io.Copy(os.Stdout, resp.BodyReader())
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, tap := range taps {
+		tap.endLine()
+	}
+	if len(resp.Trailers) > 0 {
+		if *verbose {
+			fmt.Fprintln(os.Stderr, "* Trailers:")
+		}
+		printFields(resp.Trailers)
+	}
+}
+
+func printFields(fields headers.Headers) {
+	keys, err := fields.SortedKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, key := range keys {
+		fmt.Printf("%s: %s\r\n", headers.CanonicalKey(key), fields[key])
+	}
+}
+
+// tapConn copies the bytes going each way over a connection to out, each
+// line prefixed with > for sent or < for received
+type tapConn struct {
+	net.Conn
+	out         io.Writer
+	mu          sync.Mutex
+	lastPrefix  string
+	atLineStart bool
+}
+
+func (t *tapConn) Read(p []byte) (int, error) {
+	n, err := t.Conn.Read(p)
+	t.dump("< ", p[:n])
+	return n, err
+}
+
+func (t *tapConn) Write(p []byte) (int, error) {
+	n, err := t.Conn.Write(p)
+	t.dump("> ", p[:n])
+	return n, err
+}
+
+// endLine finishes a dump that stopped partway through a line
+func (t *tapConn) endLine() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lastPrefix != "" && !t.atLineStart {
+		t.out.Write([]byte("\n"))
+		t.atLineStart = true
+	}
+}
+
+func (t *tapConn) dump(prefix string, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var b strings.Builder
+	if prefix != t.lastPrefix && !t.atLineStart && t.lastPrefix != "" {
+		b.WriteString("\n")
+		t.atLineStart = true
+	}
+	if t.lastPrefix == "" {
+		t.atLineStart = true
+	}
+	t.lastPrefix = prefix
+	for _, c := range p {
+		if t.atLineStart {
+			b.WriteString(prefix)
+			t.atLineStart = false
+		}
+		switch c {
+		case '\r':
+			b.WriteString(`\r`)
+		case '\n':
+			b.WriteString("\\n\n")
+			t.atLineStart = true
+		default:
+			b.WriteByte(c)
+		}
+	}
+	t.out.Write([]byte(b.String()))
+}
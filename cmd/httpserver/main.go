@@ -1,29 +1,30 @@
 package main
 
 import (
-	"crypto/sha256"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/jms-guy/httpfromtcp/internal/cache"
 	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/proxy"
 	"github.com/jms-guy/httpfromtcp/internal/request"
 	"github.com/jms-guy/httpfromtcp/internal/response"
 	"github.com/jms-guy/httpfromtcp/internal/server"
+	"github.com/jms-guy/httpfromtcp/internal/websocket"
 )
 
 const port = 42069
 
 func main() {
-	server, err := server.Serve(port, func(w *response.Writer, req *request.Request) {
-		switch req.RequestLine.RequestTarget {
-		case "/yourproblem":
-			w.Body = append(w.Body, []byte(`
+	router := server.NewRouter()
+	router.Handle("GET", "/yourproblem", func(w *response.Writer, req *request.Request) {
+		w.Body = append(w.Body, []byte(`
 			<html>
 				<head>
 					<title>400 Bad Request</title>
@@ -33,19 +34,20 @@ func main() {
 					<p>Your request honestly kinda sucked.</p>
 				</body>
 			</html>`)...)
-			w.Status = response.Code400
-			w.WriteStatusLine()
-			w.WriteHeaders(headers.Headers{
-				"Content-Length": fmt.Sprintf("%s", strconv.Itoa(len(w.Body))),
-				"Connection":     "close",
-				"Content-Type":   "text/html",
-			})
-			_, err := w.WriteBody()
-			if err != nil {
-				log.Println(err)
-			}
-		case "/myproblem":
-			w.Body = append(w.Body, []byte(`
+		w.Status = response.Code400
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{
+			"Content-Length": fmt.Sprintf("%s", strconv.Itoa(len(w.Body))),
+			"Connection":     "close",
+			"Content-Type":   "text/html",
+		})
+		_, err := w.WriteBody()
+		if err != nil {
+			log.Println(err)
+		}
+	})
+	router.Handle("GET", "/myproblem", func(w *response.Writer, req *request.Request) {
+		w.Body = append(w.Body, []byte(`
 			<html>
 				<head>
 					<title>500 Internal Server Error</title>
@@ -55,74 +57,101 @@ func main() {
 					<p>Okay, you know what? This one is on me.</p>
 				</body>
 			</html>`)...)
-			w.Status = response.Code500
-			w.WriteStatusLine()
-			w.WriteHeaders(headers.Headers{
-				"Content-Length": fmt.Sprintf("%s", strconv.Itoa(len(w.Body))),
-				"Connection":     "close",
-				"Content-Type":   "text/html",
-			})
-			_, err := w.WriteBody()
+		w.Status = response.Code500
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{
+			"Content-Length": fmt.Sprintf("%s", strconv.Itoa(len(w.Body))),
+			"Connection":     "close",
+			"Content-Type":   "text/html",
+		})
+		_, err := w.WriteBody()
+		if err != nil {
+			log.Println(err)
+		}
+	})
+	httpbin, err := proxy.New("https://httpbin.org")
+	if err != nil {
+		log.Fatalf("Error configuring proxy: %v", err)
+	}
+	httpbin.StripPrefix = "/httpbin"
+	router.Handle("GET", "/httpbin/html", httpbin.Handle)
+	// A comma separated list of upstreams in BALANCER_UPSTREAMS is served
+	// under /balanced, spread over them by least connections
+	if upstreams := os.Getenv("BALANCER_UPSTREAMS"); upstreams != "" {
+		balancer, err := proxy.NewLoadBalancer(proxy.LeastConnections, strings.Split(upstreams, ",")...)
+		if err != nil {
+			log.Fatalf("Error configuring load balancer: %v", err)
+		}
+		balancer.StripPrefix = "/balanced"
+		balancer.StartHealthChecks("/", 10*time.Second)
+		defer balancer.Close()
+		router.Handle("GET", "/balanced", balancer.Handle)
+	}
+	// Setting FORWARD_PROXY lets clients use the server as their HTTP proxy,
+	// tunnelling CONNECT requests and forwarding absolute-form targets
+	if os.Getenv("FORWARD_PROXY") != "" {
+		router.Proxy = proxy.NewForwardProxy().Handle
+	}
+	router.Handle("GET", "/video", func(w *response.Writer, req *request.Request) {
+		w.Status = response.Code200
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{
+			"Content-Type": "video/mp4",
+		})
+		video, err := os.ReadFile("./assets/vim.mp4")
+		if err != nil {
+			log.Println(err)
+		}
+		w.Body = append(w.Body, video...)
+		_, err = w.WriteBody()
+		if err != nil {
+			log.Println(err)
+		}
+	})
+	router.Handle("GET", "/echo", func(w *response.Writer, req *request.Request) {
+		conn, err := websocket.Upgrade(w, req)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		for {
+			messageType, message, err := conn.ReadMessage()
 			if err != nil {
-				log.Println(err)
+				return
 			}
-		case "/httpbin/html":
-			resp, err := http.Get("https://httpbin.org/html")
+			err = conn.WriteMessage(messageType, message)
 			if err != nil {
 				log.Println(err)
+				return
 			}
-			w.Status = response.Code200
-			w.WriteStatusLine()
-			w.WriteHeaders(headers.Headers{
-				"Content-Type":      "text/plain",
-				"Transfer-Encoding": "chunked",
-				"Trailer":           "X-Content-Sha256, X-Content-Length",
-			})
-			buf := make([]byte, 1024)
-			totalBytesWritten := 0
-			for {
-				n, err := resp.Body.Read(buf)
-				if err != nil {
-					if err == io.EOF {
-						break
-					}
-					log.Println(err)
-					break
-				}
-				bytesWritten, err := w.WriteChunkedBody(buf[:n])
+		}
+	})
+	router.Handle("GET", "/events", func(w *response.Writer, req *request.Request) {
+		stream, err := w.StartEventStream(15 * time.Second)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer stream.Close()
+		// A reconnecting client carries on counting from the last tick it saw
+		tick, _ := strconv.Atoi(stream.LastEventID)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stream.Done():
+				return
+			case now := <-ticker.C:
+				tick++
+				err = stream.Send(response.Event{ID: strconv.Itoa(tick), Event: "tick", Data: now.Format(time.RFC3339)})
 				if err != nil {
-					log.Println(err)
-					break
+					return
 				}
-				totalBytesWritten += bytesWritten
-			}
-			bytesWritten, err := w.WriteChunkedBodyDone()
-			if err != nil {
-				log.Println(err)
-			}
-			totalBytesWritten += bytesWritten
-			bodyHash := sha256.Sum256(w.Body)
-			w.WriteTrailers(headers.Headers{
-				"X-Content-Sha256": fmt.Sprintf("%x", bodyHash),
-				"X-Content-Length": fmt.Sprintf("%d", len(w.Body)),
-			})
-		case "/video":
-			w.Status = response.Code200
-			w.WriteStatusLine()
-			w.WriteHeaders(headers.Headers{
-				"Content-Type": "video/mp4",
-			})
-			video, err := os.ReadFile("./assets/vim.mp4")
-			if err != nil {
-				log.Println(err)
-			}
-			w.Body = append(w.Body, video...)
-			_, err = w.WriteBody()
-			if err != nil {
-				log.Println(err)
 			}
-		default:
-			w.Body = append(w.Body, []byte(`
+		}
+	})
+	router.NotFound = func(w *response.Writer, req *request.Request) {
+		w.Body = append(w.Body, []byte(`
 			<html>
 				<head>
 					<title>200 OK</title>
@@ -132,19 +161,29 @@ func main() {
 					<p>Your request was an absolute banger.</p>
 				</body>
 			</html>`)...)
-			w.Status = response.Code200
-			w.WriteStatusLine()
-			w.WriteHeaders(headers.Headers{
-				"Content-Length": fmt.Sprintf("%s", strconv.Itoa(len(w.Body))),
-				"Connection":     "close",
-				"Content-Type":   "text/html",
-			})
-			_, err := w.WriteBody()
-			if err != nil {
-				log.Println(err)
-			}
+		etag := response.ETag(w.Body, false)
+		if w.CheckPreconditions(etag, time.Time{}) {
+			return
 		}
-	})
+		w.Status = response.Code200
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{
+			"Content-Length": fmt.Sprintf("%s", strconv.Itoa(len(w.Body))),
+			"Connection":     "close",
+			"Content-Type":   "text/html",
+			"ETag":           etag,
+		})
+		_, err := w.WriteBody()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	responseCache := cache.New(0)
+	// Bodies are left for the handlers, so the proxied routes stream them
+	// upstream as they arrive
+	config := server.Config{StreamBodies: true}
+	server, err := server.ServeWithConfig(port, server.Compress(responseCache.Middleware(router.Route)), config)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
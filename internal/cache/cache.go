@@ -0,0 +1,339 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/jms-guy/httpfromtcp/internal/server"
+)
+
+const (
+	defaultMaxEntries = 1000
+	revalidateTimeout = 30 * time.Second
+	// Streamed responses larger than this are passed on but not stored
+	maxBodySize = 10 << 20
+)
+
+// Cache is a shared, in-process HTTP cache for GET and HEAD responses.
+// Responses are stored as the handler wrote them, before compression, so
+// Compress should wrap the cache rather than the other way round
+type Cache struct {
+	maxEntries int
+	mu         sync.Mutex
+	entries    map[string][]*entry
+	order      []*entry
+	now        func() time.Time
+}
+
+type entry struct {
+	key          string
+	status       response.StatusCode
+	headers      headers.Headers
+	body         []byte
+	vary         map[string]string
+	storedAt     time.Time
+	initialAge   time.Duration
+	lifetime     time.Duration
+	staleWindow  time.Duration
+	revalidating bool
+}
+
+func New(maxEntries int) *Cache {
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+	return &Cache{maxEntries: maxEntries, entries: make(map[string][]*entry), now: time.Now}
+}
+
+// ParseCacheControl splits a Cache-Control value into its directives, with
+// names lower-cased and quoted values unquoted. Directives without a value
+// map to ""
+func ParseCacheControl(value string) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(value, ",") {
+		name, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		directives[name] = strings.Trim(strings.TrimSpace(val), `"`)
+	}
+	return directives
+}
+
+func (c *Cache) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		method := req.RequestLine.Method
+		if method != "GET" && method != "HEAD" {
+			next(w, req)
+			c.invalidate(req.RequestLine.RequestTarget)
+			return
+		}
+
+		directives := ParseCacheControl(req.Headers.Get("cache-control"))
+		if _, ok := directives["no-store"]; ok {
+			next(w, req)
+			return
+		}
+		_, noCache := directives["no-cache"]
+		if directives["max-age"] == "0" || strings.Contains(req.Headers.Get("pragma"), "no-cache") {
+			noCache = true
+		}
+
+		key := method + " " + req.RequestLine.RequestTarget
+		if !noCache {
+			if e, stale := c.lookup(key, req); e != nil {
+				if stale {
+					// The handler is run on a copy, as the original is
+					// still in use answering from the cache. The copy
+					// outlives this request, so it isn't cancelled when
+					// this one ends
+					go c.revalidate(e, next, req.Clone(context.WithoutCancel(req.Context())))
+				}
+				c.serve(w, e)
+				return
+			}
+		}
+
+		w.CaptureBody(maxBodySize)
+		next(w, req)
+		c.store(key, w, req)
+	}
+}
+
+// lookup finds a stored response for the request, returning whether it's
+// only usable as stale-while-revalidate
+func (c *Cache) lookup(key string, req *request.Request) (*entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for _, e := range c.entries[key] {
+		if !e.matches(req) {
+			continue
+		}
+		age := e.age(now)
+		if age < e.lifetime {
+			return e, false
+		}
+		if age < e.lifetime+e.staleWindow && !e.revalidating {
+			e.revalidating = true
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+func (e *entry) matches(req *request.Request) bool {
+	for field, value := range e.vary {
+		if req.Headers.Get(field) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *entry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.storedAt)
+}
+
+// serve answers from a stored response, or with 304 or 412 when the request's
+// conditional headers say to
+func (c *Cache) serve(w *response.Writer, e *entry) {
+	lastModified, _ := headers.ParseTime(headerValue(e.headers, "Last-Modified"))
+	if w.CheckPreconditions(headerValue(e.headers, "ETag"), lastModified) {
+		return
+	}
+
+	c.mu.Lock()
+	age := e.age(c.now())
+	c.mu.Unlock()
+
+	h := maps.Clone(e.headers)
+	h.Del("Age")
+	h["Age"] = strconv.Itoa(int(age.Seconds()))
+	w.Status = e.status
+	w.Body = append(w.Body[:0], e.body...)
+	w.WriteStatusLine()
+	w.WriteHeaders(h)
+	w.WriteBody()
+}
+
+// revalidate refreshes a stale entry in the background, sending the
+// handler's output nowhere but the cache. It gets revalidateTimeout to finish
+func (c *Cache) revalidate(e *entry, next server.Handler, req *request.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), revalidateTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	w := &response.Writer{ResponseWriter: io.Discard, Request: req}
+	w.CaptureBody(maxBodySize)
+	next(w, req)
+	c.store(e.key, w, req)
+
+	c.mu.Lock()
+	e.revalidating = false
+	c.mu.Unlock()
+}
+
+func (c *Cache) store(key string, w *response.Writer, req *request.Request) {
+	// A response cut short, by the handler failing or the client going away,
+	// would be replayed as if it were whole
+	if w.Headers == nil || !w.Complete() {
+		return
+	}
+	body, ok := w.CapturedBody()
+	if !ok {
+		return
+	}
+	// Cookies are for the one client, and aren't in w.Headers to replay anyway
+	if len(w.Cookies()) > 0 {
+		return
+	}
+	switch w.Status {
+	case response.Code200, response.Code204, response.Code404, response.Code405:
+	default:
+		return
+	}
+
+	directives := ParseCacheControl(headerValue(w.Headers, "Cache-Control"))
+	for _, uncacheable := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := directives[uncacheable]; ok {
+			return
+		}
+	}
+	if _, ok := ParseCacheControl(req.Headers.Get("cache-control"))["no-store"]; ok {
+		return
+	}
+	if req.Headers.Get("authorization") != "" {
+		if _, ok := directives["public"]; !ok {
+			return
+		}
+	}
+
+	now := c.now()
+	lifetime, ok := freshnessLifetime(w.Headers, directives, now)
+	if !ok {
+		return
+	}
+
+	e := &entry{
+		key:      key,
+		status:   w.Status,
+		headers:  maps.Clone(w.Headers),
+		body:     append([]byte(nil), body...),
+		vary:     map[string]string{},
+		storedAt: now,
+		lifetime: lifetime,
+	}
+	// A chunked response is replayed with its now known length
+	if _, te, ok := e.headers.Find("Transfer-Encoding"); ok && strings.Contains(strings.ToLower(te), "chunked") {
+		e.headers.Del("Transfer-Encoding")
+		e.headers.Del("Trailer")
+		e.headers["Content-Length"] = strconv.Itoa(len(e.body))
+	}
+	if age, err := strconv.Atoi(headerValue(w.Headers, "Age")); err == nil && age > 0 {
+		e.initialAge = time.Duration(age) * time.Second
+	}
+	if swr, err := strconv.Atoi(directives["stale-while-revalidate"]); err == nil && swr > 0 {
+		e.staleWindow = time.Duration(swr) * time.Second
+	}
+	for _, field := range strings.Split(headerValue(w.Headers, "Vary"), ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "*" {
+			return
+		}
+		if field != "" {
+			e.vary[field] = req.Headers.Get(field)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	variants := c.entries[key]
+	for i, existing := range variants {
+		if maps.Equal(existing.vary, e.vary) {
+			variants = append(variants[:i], variants[i+1:]...)
+			c.removeFromOrder(existing)
+			break
+		}
+	}
+	c.entries[key] = append(variants, e)
+	c.order = append(c.order, e)
+	for len(c.order) > c.maxEntries {
+		c.evict(c.order[0])
+	}
+}
+
+func freshnessLifetime(h headers.Headers, directives map[string]string, now time.Time) (time.Duration, bool) {
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[name]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	expires, err := headers.ParseTime(headerValue(h, "Expires"))
+	if err != nil {
+		return 0, false
+	}
+	date, err := headers.ParseTime(headerValue(h, "Date"))
+	if err != nil {
+		date = now
+	}
+	if !expires.After(date) {
+		return 0, false
+	}
+	return expires.Sub(date), true
+}
+
+// invalidate drops stored responses for a target after an unsafe method
+// may have changed it
+func (c *Cache) invalidate(target string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, method := range []string{"GET", "HEAD"} {
+		for _, e := range c.entries[method+" "+target] {
+			c.removeFromOrder(e)
+		}
+		delete(c.entries, method+" "+target)
+	}
+}
+
+func (c *Cache) evict(e *entry) {
+	variants := c.entries[e.key]
+	for i, existing := range variants {
+		if existing == e {
+			c.entries[e.key] = append(variants[:i], variants[i+1:]...)
+			break
+		}
+	}
+	if len(c.entries[e.key]) == 0 {
+		delete(c.entries, e.key)
+	}
+	c.removeFromOrder(e)
+}
+
+func (c *Cache) removeFromOrder(e *entry) {
+	for i, existing := range c.order {
+		if existing == e {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			return
+		}
+	}
+}
+
+func headerValue(h headers.Headers, name string) string {
+	_, value, _ := h.Find(name)
+	return value
+}
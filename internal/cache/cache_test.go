@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/client"
+	"github.com/jms-guy/httpfromtcp/internal/cookie"
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/proxy"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/jms-guy/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countingHandler(calls *atomic.Int32, cacheControl string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		n := calls.Add(1)
+		w.Body = []byte("response " + strconv.Itoa(int(n)))
+		w.WriteStatusLine()
+		h := headers.Headers{"Content-Length": strconv.Itoa(len(w.Body))}
+		if cacheControl != "" {
+			h["Cache-Control"] = cacheControl
+		}
+		w.WriteHeaders(h)
+		w.WriteBody()
+	}
+}
+
+func TestParseCacheControl(t *testing.T) {
+	directives := ParseCacheControl(`max-age=60, No-Cache, private="Set-Cookie", s-maxage=120`)
+	assert.Equal(t, "60", directives["max-age"])
+	assert.Equal(t, "120", directives["s-maxage"])
+	assert.Equal(t, "Set-Cookie", directives["private"])
+	_, ok := directives["no-cache"]
+	assert.True(t, ok)
+	assert.Empty(t, ParseCacheControl(""))
+}
+
+func TestCacheMiddleware(t *testing.T) {
+	now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	// Test: Fresh response is served from the cache with an Age header
+	c := New(10)
+	c.now = clock
+	calls := &atomic.Int32{}
+	h := c.Middleware(countingHandler(calls, "max-age=60"))
+	first := servertest.Do(h, "GET", "/page", nil)
+	assert.Contains(t, first, "response 1")
+	now = now.Add(10 * time.Second)
+	second := servertest.Do(h, "GET", "/page", nil)
+	assert.Contains(t, second, "Age: 10\r\n")
+	assert.Contains(t, second, "response 1")
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Test: Expired response goes back to the handler
+	now = now.Add(time.Minute)
+	assert.Contains(t, servertest.Do(h, "GET", "/page", nil), "response 2")
+
+	// Test: Request no-cache skips the stored response
+	assert.Contains(t, servertest.Do(h, "GET", "/page", headers.Headers{"cache-control": "no-cache"}), "response 3")
+
+	// Test: Unsafe methods invalidate the target
+	servertest.Do(h, "POST", "/page", nil)
+	assert.Contains(t, servertest.Do(h, "GET", "/page", nil), "response 5")
+
+	// Test: no-store and private responses aren't stored
+	for _, cc := range []string{"no-store", "private, max-age=60", ""} {
+		c = New(10)
+		calls = &atomic.Int32{}
+		h = c.Middleware(countingHandler(calls, cc))
+		servertest.Do(h, "GET", "/page", nil)
+		servertest.Do(h, "GET", "/page", nil)
+		assert.Equal(t, int32(2), calls.Load(), cc)
+	}
+
+	// Test: Responses setting cookies aren't stored
+	c = New(10)
+	calls = &atomic.Int32{}
+	h = c.Middleware(func(w *response.Writer, req *request.Request) {
+		calls.Add(1)
+		w.SetCookie(&cookie.Cookie{Name: "session", Value: "abc"})
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": "0", "Cache-Control": "max-age=60"})
+	})
+	servertest.Do(h, "GET", "/login", nil)
+	assert.Contains(t, servertest.Do(h, "GET", "/login", nil), "Set-Cookie: session=abc\r\n")
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Test: A response cut short isn't stored
+	c = New(10)
+	calls = &atomic.Int32{}
+	h = c.Middleware(func(w *response.Writer, req *request.Request) {
+		calls.Add(1)
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Transfer-Encoding": "chunked", "Cache-Control": "max-age=60"})
+		w.WriteChunkedBody([]byte("partial"))
+	})
+	servertest.Do(h, "GET", "/cut", nil)
+	servertest.Do(h, "GET", "/cut", nil)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Test: A hit answers conditional requests from the stored validators
+	c = New(10)
+	c.now = clock
+	h = c.Middleware(func(w *response.Writer, req *request.Request) {
+		w.Body = []byte("tagged")
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": "6", "Cache-Control": "max-age=60", "ETag": `"v1"`})
+		w.WriteBody()
+	})
+	servertest.Do(h, "GET", "/tagged", nil)
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\nEtag: \"v1\"\r\n\r\n", servertest.Do(h, "GET", "/tagged", headers.Headers{"if-none-match": `"v1"`}))
+	assert.Contains(t, servertest.Do(h, "GET", "/tagged", headers.Headers{"if-none-match": `"v0"`}), "\r\n\r\ntagged")
+
+	// Test: s-maxage overrides max-age for a shared cache
+	c = New(10)
+	c.now = clock
+	calls = &atomic.Int32{}
+	h = c.Middleware(countingHandler(calls, "max-age=0, s-maxage=30"))
+	servertest.Do(h, "GET", "/page", nil)
+	now = now.Add(20 * time.Second)
+	assert.Contains(t, servertest.Do(h, "GET", "/page", nil), "response 1")
+
+	// Test: Stale response is served while it revalidates in the background
+	c = New(10)
+	c.now = clock
+	calls = &atomic.Int32{}
+	h = c.Middleware(countingHandler(calls, "max-age=10, stale-while-revalidate=60"))
+	servertest.Do(h, "GET", "/page", nil)
+	now = now.Add(30 * time.Second)
+	assert.Contains(t, servertest.Do(h, "GET", "/page", nil), "response 1")
+	require.Eventually(t, func() bool {
+		return calls.Load() == 2 && strings.Contains(servertest.Do(h, "GET", "/page", nil), "response 2")
+	}, time.Second, 10*time.Millisecond)
+
+	// Test: Variants are keyed on Vary headers
+	c = New(10)
+	c.now = clock
+	calls = &atomic.Int32{}
+	h = c.Middleware(func(w *response.Writer, req *request.Request) {
+		calls.Add(1)
+		w.Body = []byte(req.NegotiateLanguage("en", "fr"))
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": "2", "Cache-Control": "max-age=60"})
+		w.WriteBody()
+	})
+	assert.Contains(t, servertest.Do(h, "GET", "/lang", headers.Headers{"accept-language": "fr"}), "\r\n\r\nfr")
+	assert.Contains(t, servertest.Do(h, "GET", "/lang", headers.Headers{"accept-language": "en"}), "\r\n\r\nen")
+	assert.Contains(t, servertest.Do(h, "GET", "/lang", headers.Headers{"accept-language": "fr"}), "\r\n\r\nfr")
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Test: Oldest entries are evicted past the limit
+	c = New(1)
+	c.now = clock
+	calls = &atomic.Int32{}
+	h = c.Middleware(countingHandler(calls, "max-age=60"))
+	servertest.Do(h, "GET", "/a", nil)
+	servertest.Do(h, "GET", "/b", nil)
+	servertest.Do(h, "GET", "/a", nil)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestCacheRevalidateThroughProxy(t *testing.T) {
+	calls := &atomic.Int32{}
+	upstream := servertest.Serve(t, func(w *response.Writer, req *request.Request) {
+		// Slow enough that the request that set off a revalidation has
+		// ended by the time the answer comes back
+		if calls.Load() > 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		countingHandler(calls, "max-age=10, stale-while-revalidate=60")(w, req)
+	})
+	p, err := proxy.New(upstream)
+	require.NoError(t, err)
+	// The server stores a response after the client has it, so the clock is
+	// read and moved from different goroutines
+	now := &atomic.Int64{}
+	now.Store(time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC).UnixNano())
+	c := New(10)
+	c.now = func() time.Time { return time.Unix(0, now.Load()) }
+	base := servertest.Serve(t, c.Middleware(p.Handle))
+	get := func() string {
+		resp, err := (&client.Client{}).Get(base + "/page")
+		require.NoError(t, err)
+		defer resp.Close()
+		body, err := resp.ReadBody()
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	// Test: A revalidation through a proxy isn't cut off when the request
+	// that set it off ends, so the stale entry is refreshed
+	assert.Equal(t, "response 1", get())
+	now.Add(int64(30 * time.Second))
+	assert.Equal(t, "response 1", get())
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return !c.entries["GET /page"][0].revalidating
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "response 2", get())
+	assert.Equal(t, int32(2), calls.Load())
+}
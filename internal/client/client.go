@@ -0,0 +1,359 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/cookie"
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+)
+
+const defaultDialTimeout = 10 * time.Second
+
+// Client sends requests over connections it keeps open between requests to
+// the same host. DialTimeout bounds connecting and Timeout bounds sending
+// the request and getting the response head back, with zero meaning no
+// limit. Up to MaxIdleConnsPerHost connections per host are kept idle for
+// up to IdleTimeout, with zero meaning the defaults and a negative limit
+// keeping none, and MaxConnsPerHost, if set, caps the connections open to a
+// host at once, making requests wait for one to free up. The body is read
+// from the connection as the caller consumes it, and the connection is only
+// reused once the body has been read to its end. Cookies are sent from and
+// stored in Jar when it's set. Do follows redirects when FollowRedirects is
+// set, up to MaxRedirects hops, with zero meaning the default. WrapConn, if
+// set, wraps each new connection above any TLS, for watching the bytes that
+// go over it. Cancelling a request's context aborts it, whether it's waiting
+// for a connection, being sent or having its response body read
+type Client struct {
+	DialTimeout         time.Duration
+	Timeout             time.Duration
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleTimeout         time.Duration
+	DisableKeepAlives   bool
+	Jar                 *cookie.Jar
+	FollowRedirects     bool
+	MaxRedirects        int
+	WrapConn            func(conn net.Conn) net.Conn
+	poolOnce            sync.Once
+	pool                *pool
+}
+
+// Response is a response being read off a connection from the client's
+// pool. The connection goes back to the pool once the body has been read to
+// its end, and Close has to be called in case it hasn't been
+type Response struct {
+	*response.Response
+	client    *Client
+	pc        *persistConn
+	ctx       context.Context
+	stopAbort func() bool
+	reusable  bool
+	released  bool
+}
+
+func (r *Response) BodyReader() io.Reader {
+	return &releasingReader{response: r, reader: r.Response.BodyReader()}
+}
+
+func (r *Response) ReadBody() ([]byte, error) {
+	body, err := r.Response.ReadBody()
+	if err != nil {
+		return body, err
+	}
+	r.Close()
+	return body, nil
+}
+
+// Close releases the connection, back to the pool if the body was read in
+// full and otherwise by closing it, since unread body bytes would be taken
+// for the next response
+func (r *Response) Close() error {
+	if r.released {
+		return nil
+	}
+	r.released = true
+	// A connection the context aborted has a deadline in the past
+	aborted := !r.stopAbort()
+	if r.reusable && r.Complete() && !aborted {
+		r.client.putIdle(r.pc)
+		return nil
+	}
+	r.client.closeConn(r.pc)
+	return nil
+}
+
+type releasingReader struct {
+	response *Response
+	reader   io.Reader
+}
+
+func (rr *releasingReader) Read(p []byte) (int, error) {
+	n, err := rr.reader.Read(p)
+	if err == io.EOF {
+		rr.response.Close()
+	}
+	if err != nil && err != io.EOF && rr.response.ctx.Err() != nil {
+		return n, rr.response.ctx.Err()
+	}
+	return n, err
+}
+
+// RequestBodyError is returned when a request body fails to be read, as
+// opposed to failing to be sent
+type RequestBodyError struct {
+	Err error
+}
+
+func (e *RequestBodyError) Error() string {
+	return fmt.Sprintf("error reading request body: %s", e.Err)
+}
+
+func (e *RequestBodyError) Unwrap() error {
+	return e.Err
+}
+
+// NewRequest builds a request for an absolute http or https URL, which is
+// kept as the request target until the request is sent
+func NewRequest(method, rawURL string, body []byte) (*request.Request, error) {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !headers.IsToken(method) {
+		return nil, fmt.Errorf("error: invalid method %q", method)
+	}
+
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: u.String(), HttpVersion: "1.1"},
+		Headers:     headers.Headers{"host": u.Host},
+		Body:        body,
+	}, nil
+}
+
+func (c *Client) Get(rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// DoWithBody sends a request with its body streamed from body instead of
+// taken from req.Body, a nil body sending none. The request's fields have to
+// declare the body's framing themselves. Redirects aren't followed, since the
+// body can't be sent again
+func (c *Client) DoWithBody(req *request.Request, body io.Reader) (*Response, error) {
+	if body == nil {
+		body = bytes.NewReader(nil)
+	}
+	u, err := parseURL(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+	ctx := req.Context()
+
+	h := headers.NewHeaders()
+	for key, val := range req.Headers {
+		h[key] = val
+	}
+	if _, _, ok := h.Find("Host"); !ok {
+		h["host"] = u.Host
+	}
+	if _, _, ok := h.Find("Content-Length"); !ok && len(req.Body) > 0 {
+		if _, _, chunked := h.Find("Transfer-Encoding"); !chunked {
+			h["content-length"] = strconv.Itoa(len(req.Body))
+		}
+	}
+	if c.DisableKeepAlives {
+		h.Del("Connection")
+		h["connection"] = "close"
+	}
+	if c.Jar != nil {
+		addCookies(h, c.Jar.Cookies(u))
+	}
+	outbound := &request.Request{
+		RequestLine: request.RequestLine{Method: req.RequestLine.Method, RequestTarget: u.RequestURI(), HttpVersion: "1.1"},
+		Headers:     h,
+	}
+
+	key := u.Scheme + "://" + u.Host
+	for attempt := 0; ; attempt++ {
+		pc, reused, err := c.getConn(ctx, u, key)
+		if err != nil {
+			return nil, err
+		}
+		// Ending the context cuts off whatever is in progress on the
+		// connection, up to the response being closed
+		stopAbort := context.AfterFunc(ctx, func() {
+			pc.conn.SetDeadline(time.Unix(1, 0))
+		})
+		resp, sent, err := c.send(ctx, pc, outbound, body)
+		if err == nil {
+			if c.Jar != nil {
+				storeCookies(c.Jar, u, resp.SetCookies)
+			}
+			return &Response{Response: resp, client: c, pc: pc, ctx: ctx, stopAbort: stopAbort, reusable: keepAlive(outbound, resp)}, nil
+		}
+		stopAbort()
+		c.closeConn(pc)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// The server may have closed a reused connection just as it was
+		// picked. If the request never made it across, or it's idempotent,
+		// it's safe to send again on a fresh one
+		var bodyErr *RequestBodyError
+		seeker, seekable := body.(io.Seeker)
+		if !reused || attempt > 0 || !seekable || errors.As(err, &bodyErr) {
+			return nil, err
+		}
+		if sent && !Idempotent(outbound.RequestLine.Method) {
+			return nil, err
+		}
+		_, seekErr := seeker.Seek(0, io.SeekStart)
+		if seekErr != nil {
+			return nil, err
+		}
+	}
+}
+
+// send writes the request and reads the response head, reporting whether the
+// request was written in full
+func (c *Client) send(ctx context.Context, pc *persistConn, req *request.Request, body io.Reader) (*response.Response, bool, error) {
+	if c.Timeout > 0 {
+		pc.conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	// Setting a deadline could have undone one set by the context ending
+	if ctx.Err() != nil {
+		return nil, false, ctx.Err()
+	}
+
+	err := req.WriteHead(pc.conn)
+	if err == nil {
+		err = copyBody(pc.conn, body)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := pc.reader.ReadHead(req.RequestLine.Method)
+	if err != nil {
+		return nil, true, err
+	}
+	// The deadline covers getting the response head, a body can take as
+	// long as the server needs to stream it
+	pc.conn.SetDeadline(time.Time{})
+	if ctx.Err() != nil {
+		return nil, true, ctx.Err()
+	}
+
+	return resp, true, nil
+}
+
+// keepAlive reports whether the connection can carry another request once
+// this response has been read
+func keepAlive(req *request.Request, resp *response.Response) bool {
+	if headers.HasToken(req.Headers.Get("connection"), "close") || headers.HasToken(resp.Headers.Get("connection"), "close") {
+		return false
+	}
+	if resp.HttpVersion == "1.0" && !headers.HasToken(resp.Headers.Get("connection"), "keep-alive") {
+		return false
+	}
+	if resp.StatusCode == 101 || req.RequestLine.Method == "CONNECT" {
+		return false
+	}
+	// A body running until the server closes the connection leaves nothing
+	// to reuse
+	if !resp.Complete() && resp.Headers.Get("content-length") == "" && !headers.HasToken(resp.Headers.Get("transfer-encoding"), "chunked") {
+		return false
+	}
+	return true
+}
+
+// Idempotent reports whether requests with method can safely be sent twice,
+// RFC 9110 section 9.2.2
+func Idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) dial(ctx context.Context, u *url.URL) (net.Conn, error) {
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	timeout := c.DialTimeout
+	if timeout == 0 {
+		timeout = defaultDialTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if u.Scheme == "https" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: u.Hostname()}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", host)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.WrapConn != nil {
+		conn = c.WrapConn(conn)
+	}
+	return conn, nil
+}
+
+func parseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid url: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("error: url must be an absolute http or https url")
+	}
+	return u, nil
+}
+
+// copyBody streams the request body, telling a failure to read it apart from
+// a failure to send it
+func copyBody(dst io.Writer, src io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			_, writeErr := dst.Write(buf[:n])
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &RequestBodyError{Err: err}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/cookie"
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/jms-guy/httpfromtcp/internal/server"
+	"github.com/jms-guy/httpfromtcp/internal/servertest"
+	"github.com/jms-guy/httpfromtcp/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	base := servertest.Serve(t, func(w *response.Writer, req *request.Request) {
+		body, _ := req.ReadBody()
+		w.Body = []byte(fmt.Sprintf("%s %s host=%s body=%s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.Headers.Get("host"), body))
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": strconv.Itoa(len(w.Body))})
+		w.WriteBody()
+	})
+
+	// Test: GET is sent in origin form with a Host header
+	c := &Client{}
+	resp, err := c.Get(base + "/path?q=1")
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	resp.Close()
+	assert.Equal(t, response.Code200, resp.StatusCode)
+	assert.Equal(t, "GET /path?q=1 host="+base[len("http://"):]+" body=", string(body))
+
+	// Test: Body gets a Content-Length
+	req, err := NewRequest("POST", base+"/submit", []byte("payload"))
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	resp.Close()
+	assert.Contains(t, string(body), "body=payload")
+
+	// Test: A nil body streams nothing
+	req, err = NewRequest("GET", base+"/nil", nil)
+	require.NoError(t, err)
+	resp, err = c.DoWithBody(req, nil)
+	require.NoError(t, err)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	resp.Close()
+	assert.Equal(t, "GET /nil host="+base[len("http://"):]+" body=", string(body))
+
+	// Test: Only absolute http urls are accepted
+	_, err = NewRequest("GET", "/relative", nil)
+	assert.Error(t, err)
+	_, err = NewRequest("BAD METHOD", base, nil)
+	assert.Error(t, err)
+}
+
+// keepAliveServer answers every request on a connection until the client
+// closes it, counting the connections it accepts
+func keepAliveServer(t *testing.T) (string, *atomic.Int32) {
+	accepted := &atomic.Int32{}
+	addr := testutil.Listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		accepted.Add(1)
+		reader := request.NewReader(conn)
+		for {
+			req, err := reader.ReadHead()
+			if err != nil {
+				return
+			}
+			req.ReadBody()
+			body := "you asked for " + req.RequestLine.RequestTarget
+			fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+		}
+	})
+	return "http://" + addr, accepted
+}
+
+func TestClientPool(t *testing.T) {
+	// Test: Connections are reused once the body has been read
+	base, accepted := keepAliveServer(t)
+	c := &Client{}
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(base + "/" + strconv.Itoa(i))
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.BodyReader())
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, "you asked for /"+strconv.Itoa(i), string(body))
+	}
+	assert.Equal(t, int32(1), accepted.Load())
+
+	// Test: A connection with an unread body isn't reused
+	resp, err := c.Get(base + "/unread")
+	require.NoError(t, err)
+	resp.Close()
+	resp, err = c.Get(base + "/next")
+	require.NoError(t, err)
+	resp.ReadBody()
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// Test: Idle connections expire
+	base, accepted = keepAliveServer(t)
+	c = &Client{IdleTimeout: 10 * time.Millisecond}
+	resp, err = c.Get(base + "/")
+	require.NoError(t, err)
+	resp.ReadBody()
+	time.Sleep(30 * time.Millisecond)
+	resp, err = c.Get(base + "/")
+	require.NoError(t, err)
+	resp.ReadBody()
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// Test: Requests wait for a connection when the host is at its limit
+	base, accepted = keepAliveServer(t)
+	c = &Client{MaxConnsPerHost: 1}
+	first, err := c.Get(base + "/first")
+	require.NoError(t, err)
+	done := make(chan string)
+	go func() {
+		resp, err := c.Get(base + "/second")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		body, _ := resp.ReadBody()
+		done <- string(body)
+	}()
+	select {
+	case <-done:
+		t.Fatal("second request didn't wait for the first connection")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.ReadBody()
+	assert.Equal(t, "you asked for /second", <-done)
+	assert.Equal(t, int32(1), accepted.Load())
+
+	// Test: Keep-alives can be turned off
+	base, accepted = keepAliveServer(t)
+	c = &Client{DisableKeepAlives: true}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(base + "/")
+		require.NoError(t, err)
+		resp.ReadBody()
+	}
+	assert.Equal(t, int32(2), accepted.Load())
+}
+
+func TestClientRedirects(t *testing.T) {
+	redirect := func(status response.StatusCode, location string) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.Status = status
+			w.WriteStatusLine()
+			w.SetCookie(&cookie.Cookie{Name: "visited", Value: strings.Trim(req.RequestLine.RequestTarget, "/"), Path: "/"})
+			w.WriteHeaders(headers.Headers{"Location": location, "Content-Length": "0"})
+		}
+	}
+	echo := func(w *response.Writer, req *request.Request) {
+		body, _ := req.ReadBody()
+		w.Body = []byte(fmt.Sprintf("%s cookie=%s body=%s", req.RequestLine.Method, req.Headers.Get("cookie"), body))
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": strconv.Itoa(len(w.Body))})
+		w.WriteBody()
+	}
+	router := server.NewRouter()
+	router.Handle("GET", "/start", redirect(302, "step"))
+	router.Handle("GET", "/step", redirect(303, "/final"))
+	router.Handle("POST", "/post", redirect(302, "/final"))
+	router.Handle("POST", "/see-other", redirect(303, "/final"))
+	router.Handle("POST", "/keep", redirect(307, "/final"))
+	router.Handle("GET", "/loop", redirect(302, "/loop"))
+	router.Handle("GET", "/final", echo)
+	router.Handle("POST", "/final", echo)
+	base := servertest.Serve(t, router.Route)
+
+	do := func(c *Client, method, path string, body []byte) (*Response, string, error) {
+		req, err := NewRequest(method, base+path, body)
+		require.NoError(t, err)
+		resp, err := c.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		out, err := resp.ReadBody()
+		require.NoError(t, err)
+		return resp, string(out), nil
+	}
+
+	// Test: Redirects aren't followed unless asked for
+	resp, _, err := do(&Client{}, "GET", "/start", nil)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(302), resp.StatusCode)
+
+	// Test: Relative locations are followed, cookies carried along
+	c := &Client{FollowRedirects: true, Jar: cookie.NewJar()}
+	resp, body, err := do(c, "GET", "/start", nil)
+	require.NoError(t, err)
+	assert.Equal(t, response.Code200, resp.StatusCode)
+	assert.Equal(t, "GET cookie=visited=step body=", body)
+
+	// Test: 302 and 303 turn POST into a bodiless GET
+	_, body, err = do(c, "POST", "/post", []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, "GET cookie=visited=post body=", body)
+	_, body, err = do(c, "POST", "/see-other", []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, "GET cookie=visited=see-other body=", body)
+
+	// Test: 307 repeats the method and body
+	_, body, err = do(c, "POST", "/keep", []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, "POST cookie=visited=keep body=data", body)
+
+	// Test: Redirect loops are cut off
+	c = &Client{FollowRedirects: true, MaxRedirects: 3}
+	_, _, err = do(c, "GET", "/loop", nil)
+	assert.ErrorContains(t, err, "stopped after 3 redirects")
+
+	// Test: A redirect whose body is cut short fails instead of being followed
+	truncated := "http://" + testutil.Listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		request.NewReader(conn).ReadHead()
+		fmt.Fprintf(conn, "HTTP/1.1 302 Found\r\nLocation: %s/final\r\nContent-Length: 10\r\n\r\nshort", base)
+	})
+	req, err := NewRequest("GET", truncated+"/", nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	assert.Error(t, err)
+}
+
+func TestClientContext(t *testing.T) {
+	// stallServer sends the head of a response given a path of /head, then
+	// goes quiet without finishing it
+	base := "http://" + testutil.Listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		req, err := request.NewReader(conn).ReadHead()
+		if err != nil {
+			return
+		}
+		if req.RequestLine.RequestTarget == "/head" {
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\npartial")
+		}
+		io.Copy(io.Discard, conn)
+	})
+	c := &Client{}
+
+	// Test: A context that times out before the head arrives ends the request
+	req, err := NewRequest("GET", base+"/silent", nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	_, err = c.Do(req.WithContext(ctx))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Test: Cancelling while the body is read ends the read
+	req, err = NewRequest("GET", base+"/head", nil)
+	require.NoError(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	resp, err := c.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	time.AfterFunc(30*time.Millisecond, cancel)
+	body, err := io.ReadAll(resp.BodyReader())
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "partial", string(body))
+	resp.Close()
+
+	// Test: An already cancelled context never sends the request
+	_, err = c.Do(req.WithContext(ctx))
+	assert.ErrorIs(t, err, context.Canceled)
+}
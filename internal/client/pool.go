@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/response"
+)
+
+const (
+	defaultMaxIdleConnsPerHost = 2
+	defaultIdleTimeout         = 90 * time.Second
+)
+
+var errUnexpectedData = errors.New("error: idle connection received unexpected data")
+
+// persistConn is a connection the client may send more than one request
+// over. Its Reader is kept with it so any bytes read past one response are
+// there for the next
+type persistConn struct {
+	conn      net.Conn
+	reader    *response.Reader
+	key       string
+	idleSince time.Time
+	watchDone chan error
+}
+
+type pool struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	idle map[string][]*persistConn
+	open map[string]int
+}
+
+func (c *Client) connPool() *pool {
+	c.poolOnce.Do(func() {
+		c.pool = &pool{idle: make(map[string][]*persistConn), open: make(map[string]int)}
+		c.pool.cond = sync.NewCond(&c.pool.mu)
+	})
+	return c.pool
+}
+
+// getConn takes the most recently used idle connection to the host that's
+// still open, or dials a new one, waiting if MaxConnsPerHost are in use. It
+// reports whether the connection was reused
+func (c *Client) getConn(ctx context.Context, u *url.URL, key string) (*persistConn, bool, error) {
+	p := c.connPool()
+	// A request waiting for a connection gives up once its context ends
+	stop := context.AfterFunc(ctx, func() {
+		p.mu.Lock()
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	})
+	defer stop()
+
+	p.mu.Lock()
+	for {
+		if ctx.Err() != nil {
+			p.mu.Unlock()
+			return nil, false, ctx.Err()
+		}
+		c.pruneIdle(key)
+		if idle := p.idle[key]; len(idle) > 0 {
+			pc := idle[len(idle)-1]
+			p.idle[key] = idle[:len(idle)-1]
+			p.mu.Unlock()
+			if pc.take() {
+				return pc, true, nil
+			}
+			c.closeConn(pc)
+			p.mu.Lock()
+			continue
+		}
+		if c.MaxConnsPerHost <= 0 || p.open[key] < c.MaxConnsPerHost {
+			break
+		}
+		p.cond.Wait()
+	}
+	p.open[key]++
+	p.mu.Unlock()
+
+	conn, err := c.dial(ctx, u)
+	if err != nil {
+		p.mu.Lock()
+		p.open[key]--
+		p.cond.Broadcast()
+		p.mu.Unlock()
+		return nil, false, err
+	}
+	return &persistConn{conn: conn, reader: response.NewReader(conn), key: key}, false, nil
+}
+
+// putIdle keeps a connection whose last response was read in full for the
+// next request to the same host, unless there are enough idle already
+func (c *Client) putIdle(pc *persistConn) {
+	maxIdle := c.MaxIdleConnsPerHost
+	if maxIdle == 0 {
+		maxIdle = defaultMaxIdleConnsPerHost
+	}
+
+	p := c.connPool()
+	p.mu.Lock()
+	if c.DisableKeepAlives || len(p.idle[pc.key]) >= maxIdle {
+		p.mu.Unlock()
+		c.closeConn(pc)
+		return
+	}
+	pc.idleSince = time.Now()
+	p.idle[pc.key] = append(p.idle[pc.key], pc)
+	pc.watch(c)
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+func (c *Client) closeConn(pc *persistConn) {
+	pc.conn.Close()
+	p := c.connPool()
+	p.mu.Lock()
+	p.open[pc.key]--
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// CloseIdleConnections closes every connection sitting idle in the pool
+func (c *Client) CloseIdleConnections() {
+	p := c.connPool()
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = make(map[string][]*persistConn)
+	p.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, pc := range conns {
+			pc.take()
+			c.closeConn(pc)
+		}
+	}
+}
+
+// pruneIdle closes idle connections to the host that have outlived the idle
+// timeout, with the pool locked
+func (c *Client) pruneIdle(key string) {
+	timeout := c.IdleTimeout
+	if timeout == 0 {
+		timeout = defaultIdleTimeout
+	}
+
+	p := c.pool
+	kept := p.idle[key][:0]
+	for _, pc := range p.idle[key] {
+		if time.Since(pc.idleSince) < timeout {
+			kept = append(kept, pc)
+			continue
+		}
+		p.open[key]--
+		go func(pc *persistConn) {
+			pc.take()
+			pc.conn.Close()
+		}(pc)
+	}
+	p.idle[key] = kept
+}
+
+// watch reads from an idle connection in the background, so one the server
+// closes is dropped from the pool straight away rather than failing the next
+// request sent over it
+func (pc *persistConn) watch(c *Client) {
+	done := make(chan error, 1)
+	pc.watchDone = done
+	go func() {
+		var b [1]byte
+		_, err := pc.conn.Read(b[:])
+		if err == nil {
+			err = errUnexpectedData
+		}
+		done <- err
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+
+		p := c.connPool()
+		p.mu.Lock()
+		idle := p.idle[pc.key]
+		for i, candidate := range idle {
+			if candidate == pc {
+				p.idle[pc.key] = append(idle[:i], idle[i+1:]...)
+				p.mu.Unlock()
+				c.closeConn(pc)
+				return
+			}
+		}
+		p.mu.Unlock()
+	}()
+}
+
+// take stops the background read on an idle connection, reporting whether
+// the connection is still fit to use
+func (pc *persistConn) take() bool {
+	if pc.watchDone == nil {
+		return true
+	}
+	pc.conn.SetReadDeadline(time.Unix(1, 0))
+	err := <-pc.watchDone
+	pc.watchDone = nil
+	pc.conn.SetReadDeadline(time.Time{})
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jms-guy/httpfromtcp/internal/cookie"
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+)
+
+const defaultMaxRedirects = 10
+
+// Do sends a request whose target is an absolute URL, as NewRequest builds,
+// following redirects if the client is set to
+func (c *Client) Do(req *request.Request) (*Response, error) {
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	for hops := 0; ; hops++ {
+		resp, err := c.DoWithBody(req, bytes.NewReader(req.Body))
+		if err != nil || !c.FollowRedirects {
+			return resp, err
+		}
+		next, err := redirectRequest(req, resp)
+		if err != nil {
+			resp.Close()
+			return nil, err
+		}
+		if next == nil {
+			return resp, nil
+		}
+		if hops >= maxRedirects {
+			resp.Close()
+			return nil, fmt.Errorf("error: stopped after %d redirects", maxRedirects)
+		}
+		// Reading the body lets the connection go back to the pool, one
+		// that couldn't be read is closed instead
+		_, err = resp.ReadBody()
+		if err != nil {
+			resp.reusable = false
+			resp.Close()
+			return nil, err
+		}
+		resp.Close()
+		req = next
+	}
+}
+
+// redirectRequest builds the request that follows a redirect response, or
+// returns nil if the response isn't one to follow. 301 and 302 turn POST into
+// GET and 303 turns everything but HEAD into GET, dropping the body, while
+// 307 and 308 repeat the request as it was, RFC 9110 section 15.4
+func redirectRequest(req *request.Request, resp *Response) (*request.Request, error) {
+	method := req.RequestLine.Method
+	body := req.Body
+	switch resp.StatusCode {
+	case 301, 302:
+		if method == "POST" {
+			method, body = "GET", nil
+		}
+	case 303:
+		if method != "HEAD" {
+			method, body = "GET", nil
+		}
+	case 307, 308:
+	default:
+		return nil, nil
+	}
+
+	location := resp.Headers.Get("location")
+	if location == "" {
+		return nil, nil
+	}
+	current, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+	target, err := current.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid redirect location %q", location)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, fmt.Errorf("error: redirect to unsupported scheme %q", target.Scheme)
+	}
+
+	h := headers.NewHeaders()
+	for key, val := range req.Headers {
+		h[key] = val
+	}
+	h["host"] = target.Host
+	if body == nil {
+		for _, name := range []string{"Content-Length", "Content-Type", "Content-Encoding", "Transfer-Encoding"} {
+			h.Del(name)
+		}
+	}
+	// Credentials meant for one host aren't handed to another, the jar
+	// supplies any cookies the new host should get
+	if !strings.EqualFold(target.Host, current.Host) {
+		h.Del("Authorization")
+		h.Del("Cookie")
+	}
+
+	next := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target.String(), HttpVersion: "1.1"},
+		Headers:     h,
+		Body:        body,
+	}
+	return next.WithContext(req.Context()), nil
+}
+
+func addCookies(h headers.Headers, cookies []*cookie.Cookie) {
+	if len(cookies) == 0 {
+		return
+	}
+	pairs := []string{}
+	if _, existing, ok := h.Find("Cookie"); ok && existing != "" {
+		pairs = append(pairs, existing)
+	}
+	for _, c := range cookies {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	h.Del("Cookie")
+	h["cookie"] = strings.Join(pairs, "; ")
+}
+
+func storeCookies(jar *cookie.Jar, u *url.URL, setCookies []string) {
+	cookies := []*cookie.Cookie{}
+	for _, value := range setCookies {
+		c, err := cookie.ParseSetCookie(value)
+		if err == nil {
+			cookies = append(cookies, c)
+		}
+	}
+	jar.SetCookies(u, cookies)
+}
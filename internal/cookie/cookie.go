@@ -0,0 +1,229 @@
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+)
+
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+// MaxAge of 0 leaves the attribute out, a negative MaxAge deletes the cookie
+type Cookie struct {
+	Name     string
+	Value    string
+	Path     string
+	Domain   string
+	Expires  time.Time
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+}
+
+// Encode returns the cookie serialized as a Set-Cookie field value
+func (c *Cookie) Encode() (string, error) {
+	if !headers.IsToken(c.Name) {
+		return "", fmt.Errorf("error: invalid cookie name %q", c.Name)
+	}
+	if !validValue(c.Value) {
+		return "", fmt.Errorf("error: invalid cookie value for %q", c.Name)
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteString("=")
+	b.WriteString(c.Value)
+
+	if c.Path != "" {
+		if !validAttribute(c.Path) {
+			return "", fmt.Errorf("error: invalid cookie path %q", c.Path)
+		}
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		if !validAttribute(c.Domain) {
+			return "", fmt.Errorf("error: invalid cookie domain %q", c.Domain)
+		}
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(headers.TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	default:
+	}
+
+	return b.String(), nil
+}
+
+// Parse reads the name=value pairs of a Cookie request header, skipping any
+// pair that isn't well formed
+func Parse(header string) []*Cookie {
+	cookies := []*Cookie{}
+	for _, pair := range strings.Split(header, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !headers.IsToken(name) {
+			continue
+		}
+		if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if !validValue(value) {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+
+	return cookies
+}
+
+// ParseSetCookie reads a Set-Cookie field value. Unknown or malformed
+// attributes are ignored, as RFC 6265 section 5.2 asks, and a Max-Age of
+// zero or less comes back as a negative MaxAge
+func ParseSetCookie(value string) (*Cookie, error) {
+	parts := strings.Split(value, ";")
+	name, val, ok := strings.Cut(parts[0], "=")
+	name = strings.TrimSpace(name)
+	val = strings.TrimSpace(val)
+	if !ok || !headers.IsToken(name) {
+		return nil, fmt.Errorf("error: malformed set-cookie %q", value)
+	}
+	if len(val) > 1 && val[0] == '"' && val[len(val)-1] == '"' {
+		val = val[1 : len(val)-1]
+	}
+	if !validValue(val) {
+		return nil, fmt.Errorf("error: invalid cookie value for %q", name)
+	}
+
+	c := &Cookie{Name: name, Value: val}
+	for _, attr := range parts[1:] {
+		key, attrVal, _ := strings.Cut(attr, "=")
+		attrVal = strings.TrimSpace(attrVal)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "path":
+			if strings.HasPrefix(attrVal, "/") {
+				c.Path = attrVal
+			}
+		case "domain":
+			c.Domain = strings.ToLower(strings.TrimPrefix(attrVal, "."))
+		case "expires":
+			expires, err := parseExpires(attrVal)
+			if err == nil {
+				c.Expires = expires
+			}
+		case "max-age":
+			maxAge, err := strconv.Atoi(attrVal)
+			if err != nil {
+				continue
+			}
+			if maxAge <= 0 {
+				maxAge = -1
+			}
+			c.MaxAge = maxAge
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(attrVal) {
+			case "lax":
+				c.SameSite = SameSiteLax
+			case "strict":
+				c.SameSite = SameSiteStrict
+			case "none":
+				c.SameSite = SameSiteNone
+			default:
+			}
+		default:
+		}
+	}
+
+	return c, nil
+}
+
+// parseExpires reads an Expires date in any of the HTTP date formats, or in
+// the dashed form with a four digit year that servers commonly send
+func parseExpires(value string) (time.Time, error) {
+	expires, err := headers.ParseTime(value)
+	if err == nil {
+		return expires, nil
+	}
+	expires, dashedErr := time.Parse("Mon, 02-Jan-2006 15:04:05 GMT", value)
+	if dashedErr == nil {
+		return expires, nil
+	}
+	return time.Time{}, err
+}
+
+// SplitSetCookie undoes the joining of several Set-Cookie fields into one
+// comma separated value. Commas also turn up inside Expires dates, so a
+// comma only counts as a separator when what follows looks like the start of
+// another name=value pair
+func SplitSetCookie(value string) []string {
+	values := []string{}
+	start := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] != ',' {
+			continue
+		}
+		rest := strings.TrimLeft(value[i+1:], " \t")
+		name, _, ok := strings.Cut(rest, "=")
+		if ok && headers.IsToken(name) {
+			values = append(values, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(value[start:]); last != "" {
+		values = append(values, last)
+	}
+	return values
+}
+
+// cookie-octet from RFC 6265, excluding whitespace, DQUOTE, comma, semicolon and backslash
+func validValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func validAttribute(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c == 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package cookie
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieParse(t *testing.T) {
+	// Test: Multiple cookies
+	cookies := Parse("session=abc123; theme=dark;lang=\"en\"")
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "theme", cookies[1].Name)
+	assert.Equal(t, "dark", cookies[1].Value)
+	assert.Equal(t, "en", cookies[2].Value)
+
+	// Test: Malformed pairs are skipped
+	cookies = Parse("novalue; b@d=1; ok=yes; spaced=a b")
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+
+	// Test: Empty header
+	cookies = Parse("")
+	assert.Empty(t, cookies)
+}
+
+func TestCookieString(t *testing.T) {
+	// Test: Name and value only
+	c := &Cookie{Name: "session", Value: "abc123"}
+	s, err := c.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc123", s)
+
+	// Test: All attributes
+	c = &Cookie{
+		Name:     "session",
+		Value:    "abc123",
+		Path:     "/",
+		Domain:   ".example.com",
+		Expires:  time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC),
+		MaxAge:   3600,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: SameSiteStrict,
+	}
+	s, err = c.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Strict", s)
+
+	// Test: Negative MaxAge deletes the cookie
+	c = &Cookie{Name: "session", MaxAge: -1}
+	s, err = c.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, "session=; Max-Age=0", s)
+
+	// Test: Invalid name
+	c = &Cookie{Name: "bad name", Value: "x"}
+	_, err = c.Encode()
+	require.Error(t, err)
+
+	// Test: Value with injected attribute
+	c = &Cookie{Name: "session", Value: "x; Domain=evil.com"}
+	_, err = c.Encode()
+	require.Error(t, err)
+}
+
+func TestParseSetCookie(t *testing.T) {
+	// Test: Attributes are read case-insensitively
+	c, err := ParseSetCookie(`id="a3fWa"; expires=Wed, 21 Oct 2015 07:28:00 GMT; Max-Age=60; DOMAIN=.Example.com; Path=/docs; Secure; httponly; SameSite=Lax; Unknown=1`)
+	require.NoError(t, err)
+	assert.Equal(t, "id", c.Name)
+	assert.Equal(t, "a3fWa", c.Value)
+	assert.Equal(t, time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC), c.Expires)
+	assert.Equal(t, 60, c.MaxAge)
+	assert.Equal(t, "example.com", c.Domain)
+	assert.Equal(t, "/docs", c.Path)
+	assert.True(t, c.Secure)
+	assert.True(t, c.HttpOnly)
+	assert.Equal(t, SameSiteLax, c.SameSite)
+
+	// Test: Expires is also read in the obsolete and dashed date formats
+	for _, expires := range []string{"Wednesday, 21-Oct-15 07:28:00 GMT", "Wed Oct 21 07:28:00 2015", "Wed, 21-Oct-2015 07:28:00 GMT"} {
+		c, err = ParseSetCookie("id=1; Expires=" + expires)
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC), c.Expires, expires)
+	}
+
+	// Test: Max-Age of zero deletes
+	c, err = ParseSetCookie("id=; Max-Age=0")
+	require.NoError(t, err)
+	assert.Equal(t, -1, c.MaxAge)
+
+	// Test: Malformed name
+	_, err = ParseSetCookie("no equals sign")
+	assert.Error(t, err)
+}
+
+func TestSplitSetCookie(t *testing.T) {
+	// Test: Commas inside Expires don't split
+	values := SplitSetCookie("a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Path=/, b=2, c=3; HttpOnly")
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Path=/", "b=2", "c=3; HttpOnly"}, values)
+	assert.Empty(t, SplitSetCookie(""))
+}
+
+func TestJar(t *testing.T) {
+	now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	jar := NewJar()
+	jar.now = func() time.Time { return now }
+	u, _ := url.Parse("http://www.example.com/docs/page")
+	names := func(rawURL string) []string {
+		target, _ := url.Parse(rawURL)
+		out := []string{}
+		for _, c := range jar.Cookies(target) {
+			out = append(out, c.Name+"="+c.Value)
+		}
+		return out
+	}
+
+	jar.SetCookies(u, []*Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: "example.com", Path: "/"},
+		{Name: "deep", Value: "3", Path: "/docs/page"},
+		{Name: "secure", Value: "4", Secure: true, Path: "/"},
+		{Name: "short", Value: "5", MaxAge: 10, Path: "/"},
+		{Name: "foreign", Value: "6", Domain: "other.com"},
+	})
+
+	// Test: Longest path first, host-only and default path rules applied
+	assert.Equal(t, []string{"deep=3", "host=1", "domain=2", "short=5"}, names("http://www.example.com/docs/page"))
+
+	// Test: Domain cookies reach subdomains, host-only ones don't
+	assert.Equal(t, []string{"domain=2"}, names("http://api.www.example.com/"))
+	assert.Equal(t, []string{"domain=2"}, names("http://example.com/"))
+
+	// Test: Path matching stops at segment boundaries
+	assert.Equal(t, []string{"domain=2", "short=5"}, names("http://www.example.com/docsextra"))
+
+	// Test: Secure cookies only go over https
+	assert.Contains(t, names("https://www.example.com/"), "secure=4")
+
+	// Test: Expired cookies are dropped
+	now = now.Add(time.Minute)
+	assert.NotContains(t, names("http://www.example.com/"), "short=5")
+
+	// Test: A negative MaxAge removes the stored cookie
+	jar.SetCookies(u, []*Cookie{{Name: "domain", Domain: "example.com", Path: "/", MaxAge: -1}})
+	assert.NotContains(t, names("http://www.example.com/"), "domain=2")
+}
@@ -0,0 +1,149 @@
+package cookie
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Jar is an in-memory cookie store for a client, following the storage and
+// retrieval rules of RFC 6265 section 5.3 and 5.4. Public suffixes aren't
+// known to it, so it's only suited to talking to trusted hosts
+type Jar struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	now     func() time.Time
+	seq     uint64
+}
+
+type entry struct {
+	cookie   Cookie
+	hostOnly bool
+	expires  time.Time
+	created  uint64
+}
+
+func NewJar() *Jar {
+	return &Jar{entries: make(map[string]*entry), now: time.Now}
+}
+
+// SetCookies stores cookies received in a response to u, dropping any whose
+// Domain doesn't cover u's host and removing stored ones they expire
+func (j *Jar) SetCookies(u *url.URL, cookies []*Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	host := strings.ToLower(u.Hostname())
+	now := j.now()
+	for _, c := range cookies {
+		e := &entry{cookie: *c, hostOnly: c.Domain == ""}
+		if e.hostOnly {
+			e.cookie.Domain = host
+		} else if !domainMatch(host, e.cookie.Domain) || (net.ParseIP(host) != nil && host != e.cookie.Domain) {
+			continue
+		}
+		if e.cookie.Path == "" {
+			e.cookie.Path = defaultPath(u.Path)
+		}
+
+		key := e.cookie.Domain + ";" + e.cookie.Path + ";" + e.cookie.Name
+		switch {
+		case c.MaxAge < 0:
+			delete(j.entries, key)
+			continue
+		case c.MaxAge > 0:
+			e.expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			if !c.Expires.After(now) {
+				delete(j.entries, key)
+				continue
+			}
+			e.expires = c.Expires
+		}
+
+		// A replaced cookie keeps its place in the sending order
+		if existing, ok := j.entries[key]; ok {
+			e.created = existing.created
+		} else {
+			j.seq++
+			e.created = j.seq
+		}
+		j.entries[key] = e
+	}
+}
+
+// Cookies returns the stored cookies to send with a request to u, longest
+// path first
+func (j *Jar) Cookies(u *url.URL) []*Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	host := strings.ToLower(u.Hostname())
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	now := j.now()
+
+	matched := []*entry{}
+	for key, e := range j.entries {
+		if !e.expires.IsZero() && !e.expires.After(now) {
+			delete(j.entries, key)
+			continue
+		}
+		if e.hostOnly && host != e.cookie.Domain {
+			continue
+		}
+		if !e.hostOnly && !domainMatch(host, e.cookie.Domain) {
+			continue
+		}
+		if !pathMatch(path, e.cookie.Path) {
+			continue
+		}
+		if e.cookie.Secure && u.Scheme != "https" {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	sort.Slice(matched, func(a, b int) bool {
+		if len(matched[a].cookie.Path) != len(matched[b].cookie.Path) {
+			return len(matched[a].cookie.Path) > len(matched[b].cookie.Path)
+		}
+		return matched[a].created < matched[b].created
+	})
+
+	cookies := make([]*Cookie, 0, len(matched))
+	for _, e := range matched {
+		cookies = append(cookies, &Cookie{Name: e.cookie.Name, Value: e.cookie.Value})
+	}
+	return cookies
+}
+
+func domainMatch(host, domain string) bool {
+	return host == domain || (strings.HasSuffix(host, "."+domain) && net.ParseIP(host) == nil)
+}
+
+func pathMatch(requestPath, cookiePath string) bool {
+	if requestPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || requestPath[len(cookiePath)] == '/'
+}
+
+// defaultPath is the directory of the request path, RFC 6265 section 5.1.4
+func defaultPath(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
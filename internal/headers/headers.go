@@ -2,12 +2,41 @@ package headers
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 	"unicode"
 )
 
 var specialTchars = []rune("!#$%&'*+-.^_`|~")
 
+// TimeFormat is the IMF-fixdate layout used by Date, Expires, Last-Modified
+// and the other date valued fields
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// The obsolete date formats recipients still have to accept, RFC 9110
+// section 5.6.7
+var obsoleteTimeFormats = []string{
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// ParseTime parses an HTTP date in IMF-fixdate or either obsolete format,
+// RFC 850 or asctime
+func ParseTime(value string) (time.Time, error) {
+	t, err := time.Parse(TimeFormat, value)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range obsoleteTimeFormats {
+		t, obsErr := time.Parse(layout, value)
+		if obsErr == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
 type Headers map[string]string
 
 func NewHeaders() Headers {
@@ -19,25 +48,62 @@ func (h Headers) Get(key string) string {
 	return h[lowerKey]
 }
 
+// Find looks up a field regardless of the casing its key was stored with,
+// for maps built by handlers rather than by Parse
+func (h Headers) Find(name string) (key, value string, ok bool) {
+	for key, value := range h {
+		if strings.EqualFold(key, name) {
+			return key, value, true
+		}
+	}
+	return "", "", false
+}
+
+// Del removes every key matching name regardless of casing
+func (h Headers) Del(name string) {
+	for key := range h {
+		if strings.EqualFold(key, name) {
+			delete(h, key)
+		}
+	}
+}
+
+// Parse reads a single field line. Obsolete line folding is rejected, as
+// RFC 9112 requires of servers that don't unfold it
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.parse(data, false)
+}
+
+// ParseLenient reads a single field line, unfolding any obs-fold continuation
+// lines that follow it into its value. It needs to see the start of the next
+// line before it can finish the current one
+func (h Headers) ParseLenient(data []byte) (n int, done bool, err error) {
+	return h.parse(data, true)
+}
+
+func (h Headers) parse(data []byte, unfold bool) (int, bool, error) {
 	// No CRLF found
-	if !strings.Contains(string(data), "\r\n") {
+	lineEnd := strings.Index(string(data), "\r\n")
+	if lineEnd == -1 {
 		return 0, false, nil
 	}
 	// CRLF at start of data, end of headers
-	if string(data[:2]) == "\r\n" {
+	if lineEnd == 0 {
 		return 2, true, nil
 	}
 
-	headerLines := strings.Split(string(data), "\r\n")
-	header := headerLines[0]
+	header := string(data[:lineEnd])
+	if isFoldWhitespace(header[0]) {
+		return 0, false, fmt.Errorf("error: obsolete line folding in header")
+	}
+	bytesParsed := lineEnd + 2
 
 	key, value, yes := strings.Cut(header, ":")
 	if !yes {
 		return 0, false, fmt.Errorf("error: malformed header")
 	}
 
-	if len(key) > len(strings.TrimRightFunc(key, unicode.IsSpace)) {
+	if len(key) > len(strings.TrimRight(key, " \t")) {
 		return 0, false, fmt.Errorf("error: header key not formatted correctly")
 	}
 
@@ -46,33 +112,140 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	if isInvalid {
 		return 0, false, fmt.Errorf("error: invalid character in header")
 	}
-	finalKey := strings.TrimSpace(lowerKey)
-	finalValue := strings.TrimSpace(value)
+	finalValue := trimOWS(value)
+
+	if unfold {
+		for {
+			rest := data[bytesParsed:]
+			if len(rest) == 0 {
+				return 0, false, nil
+			}
+			if !isFoldWhitespace(rest[0]) {
+				break
+			}
+			foldEnd := strings.Index(string(rest), "\r\n")
+			if foldEnd == -1 {
+				return 0, false, nil
+			}
+			continuation := trimOWS(string(rest[:foldEnd]))
+			if continuation != "" {
+				finalValue = trimOWS(finalValue + " " + continuation)
+			}
+			bytesParsed += foldEnd + 2
+		}
+	}
+
+	if !ValidFieldValue(finalValue) {
+		return 0, false, fmt.Errorf("error: invalid character in header value")
+	}
 
-	if value, ok := h[finalKey]; ok {
-		h[finalKey] = fmt.Sprintf("%s, %s", value, finalValue)
+	if value, ok := h[lowerKey]; ok {
+		h[lowerKey] = fmt.Sprintf("%s, %s", value, finalValue)
 	} else {
-		h[finalKey] = finalValue
+		h[lowerKey] = finalValue
 	}
 
-	return len([]byte(header)) + 2, false, nil
+	return bytesParsed, false, nil
+}
+
+// CanonicalKey returns a field name with the first letter and any letter
+// following a hyphen upper-cased, e.g. content-type becomes Content-Type.
+// Names that aren't tokens are returned unchanged
+func CanonicalKey(key string) string {
+	if !IsToken(key) {
+		return key
+	}
+	canonical := []byte(key)
+	upper := true
+	for i, c := range canonical {
+		if upper && 'a' <= c && c <= 'z' {
+			canonical[i] = c - ('a' - 'A')
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			canonical[i] = c + ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(canonical)
+}
+
+// SortedKeys returns the keys ordered by canonical name, for writing fields
+// out deterministically. It fails if any name isn't a token or any value
+// contains CR, LF or NUL, since writing those would split the message, and
+// if two keys differ only in case, since the field would go out twice and
+// recipients could disagree on which one counts
+func (h Headers) SortedKeys() ([]string, error) {
+	keys := make([]string, 0, len(h))
+	seen := make(map[string]string, len(h))
+	for key, val := range h {
+		if !IsToken(key) {
+			return nil, fmt.Errorf("error: invalid field name %q", key)
+		}
+		if !ValidFieldValue(val) {
+			return nil, fmt.Errorf("error: invalid value for field %q", key)
+		}
+		lowerKey := strings.ToLower(key)
+		if other, ok := seen[lowerKey]; ok {
+			return nil, fmt.Errorf("error: field set twice as %q and %q", other, key)
+		}
+		seen[lowerKey] = key
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		canonicalI, canonicalJ := CanonicalKey(keys[i]), CanonicalKey(keys[j])
+		if canonicalI != canonicalJ {
+			return canonicalI < canonicalJ
+		}
+		return keys[i] < keys[j]
+	})
+	return keys, nil
+}
+
+// HasToken reports whether token is one of the comma separated elements of a
+// field value such as Connection, compared case-insensitively
+func HasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidFieldValue reports whether s is free of the bare CR, LF and NUL
+// characters that would let a value break out of its field line
+func ValidFieldValue(s string) bool {
+	return !strings.ContainsAny(s, "\r\n\x00")
+}
+
+func isFoldWhitespace(b byte) bool {
+	return b == ' ' || b == '\t'
+}
+
+func trimOWS(s string) string {
+	return strings.Trim(s, " \t")
 }
 
 func checkForInvalidKeyChar(s string) bool {
-	invalid := false
-	found := false
+	return !IsToken(s)
+}
+
+// IsToken reports whether s is a valid RFC 9110 token, the grammar used for
+// field names, methods and cookie names
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
 	for _, char := range s {
-		if !unicode.IsLetter(char) && !unicode.IsDigit(char) {
-			for _, specialChar := range specialTchars {
-				if char == specialChar {
-					found = true
-				}
-			}
-			if !found {
-				invalid = true
-			}
+		if char > unicode.MaxASCII {
+			return false
+		}
+		if unicode.IsLetter(char) || unicode.IsDigit(char) {
+			continue
+		}
+		if !strings.ContainsRune(string(specialTchars), char) {
+			return false
 		}
 	}
 
-	return invalid
+	return true
 }
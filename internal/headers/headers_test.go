@@ -68,3 +68,81 @@ func TestHeadersParse(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.Error(t, err)
 }
+
+func TestHeadersObsFold(t *testing.T) {
+	// Test: Obsolete line folding is rejected by default
+	headers := NewHeaders()
+	data := []byte("X-Long: first\r\n  second\r\n\r\n")
+	n, done, err := headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, 15, n)
+	assert.False(t, done)
+	_, _, err = headers.Parse(data[n:])
+	require.Error(t, err)
+
+	// Test: Obsolete line folding is unfolded in lenient mode
+	headers = NewHeaders()
+	n, done, err = headers.ParseLenient(data)
+	require.NoError(t, err)
+	assert.Equal(t, "first second", headers["x-long"])
+	assert.Equal(t, 25, n)
+	assert.False(t, done)
+
+	// Test: Multiple folded lines with tabs
+	headers = NewHeaders()
+	data = []byte("X-Long: a\r\n\tb\r\n \t c \r\nHost: localhost\r\n\r\n")
+	n, done, err = headers.ParseLenient(data)
+	require.NoError(t, err)
+	assert.Equal(t, "a b c", headers["x-long"])
+	assert.Equal(t, 22, n)
+	assert.False(t, done)
+
+	// Test: Lenient mode waits for the start of the next line
+	headers = NewHeaders()
+	data = []byte("X-Long: first\r\n")
+	n, done, err = headers.ParseLenient(data)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Leading whitespace on the first line is rejected in lenient mode
+	headers = NewHeaders()
+	data = []byte(" X-Long: first\r\n\r\n")
+	_, _, err = headers.ParseLenient(data)
+	require.Error(t, err)
+}
+
+func TestHeadersValueValidation(t *testing.T) {
+	// Test: Bare LF in value
+	headers := NewHeaders()
+	data := []byte("X-Bad: one\ntwo\r\n\r\n")
+	_, _, err := headers.Parse(data)
+	require.Error(t, err)
+
+	// Test: Bare CR in value
+	headers = NewHeaders()
+	data = []byte("X-Bad: one\rtwo\r\n\r\n")
+	_, _, err = headers.Parse(data)
+	require.Error(t, err)
+
+	// Test: NUL in value
+	headers = NewHeaders()
+	data = []byte("X-Bad: one\x00two\r\n\r\n")
+	_, _, err = headers.Parse(data)
+	require.Error(t, err)
+
+	// Test: Only spaces and tabs are trimmed from values
+	headers = NewHeaders()
+	data = []byte("X-Good: \t value  \r\n\r\n")
+	_, _, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "value ", headers["x-good"])
+}
+
+func TestCanonicalKey(t *testing.T) {
+	assert.Equal(t, "Content-Type", CanonicalKey("content-type"))
+	assert.Equal(t, "Content-Type", CanonicalKey("CONTENT-TYPE"))
+	assert.Equal(t, "X-Content-Sha256", CanonicalKey("x-content-sha256"))
+	assert.Equal(t, "Www-Authenticate", CanonicalKey("www-authenticate"))
+	assert.Equal(t, "bad key", CanonicalKey("bad key"))
+}
@@ -0,0 +1,310 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/client"
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/jms-guy/httpfromtcp/internal/server"
+)
+
+type Policy int
+
+const (
+	RoundRobin Policy = iota
+	LeastConnections
+	ConsistentHash
+)
+
+const (
+	defaultMaxRetries   = 2
+	defaultMaxFailures  = 3
+	defaultEjectionTime = 30 * time.Second
+	ringReplicas        = 100
+)
+
+// LoadBalancer spreads requests over a pool of upstreams, proxying each one
+// the way ReverseProxy does. Upstreams that fail MaxFailures times in a row,
+// by not answering or answering 502, 503 or 504, are left out for
+// EjectionTime, and ones failing the active health check are left out until
+// they pass it again. Idempotent requests that get no response are retried on
+// other upstreams up to MaxRetries times, which means their body is read in
+// full before the first attempt. HashKey picks what ConsistentHash is keyed
+// on, the client's IP by default
+type LoadBalancer struct {
+	Policy          Policy
+	HashKey         func(req *request.Request) string
+	StripPrefix     string
+	DialTimeout     time.Duration
+	ResponseTimeout time.Duration
+	MaxRetries      int
+	MaxFailures     int
+	EjectionTime    time.Duration
+	backends        []*backend
+	ring            []ringPoint
+	next            atomic.Uint64
+	stop            chan struct{}
+	stopOnce        sync.Once
+	transport       *client.Client
+	transportOnce   sync.Once
+}
+
+type backend struct {
+	url          *url.URL
+	active       atomic.Int64
+	mu           sync.Mutex
+	unhealthy    bool
+	failures     int
+	ejectedUntil time.Time
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend *backend
+}
+
+func NewLoadBalancer(policy Policy, upstreams ...string) (*LoadBalancer, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("error: load balancer needs at least one upstream")
+	}
+
+	lb := &LoadBalancer{
+		Policy:          policy,
+		DialTimeout:     defaultDialTimeout,
+		ResponseTimeout: defaultResponseTimeout,
+		MaxRetries:      defaultMaxRetries,
+		MaxFailures:     defaultMaxFailures,
+		EjectionTime:    defaultEjectionTime,
+		stop:            make(chan struct{}),
+	}
+	for _, upstream := range upstreams {
+		p, err := New(upstream)
+		if err != nil {
+			return nil, err
+		}
+		b := &backend{url: p.Upstream}
+		lb.backends = append(lb.backends, b)
+		for i := 0; i < ringReplicas; i++ {
+			lb.ring = append(lb.ring, ringPoint{hash: hashKey(upstream + "#" + strconv.Itoa(i)), backend: b})
+		}
+	}
+	sort.Slice(lb.ring, func(i, j int) bool {
+		return lb.ring[i].hash < lb.ring[j].hash
+	})
+
+	return lb, nil
+}
+
+func (lb *LoadBalancer) Handle(w *response.Writer, req *request.Request) {
+	retries := 0
+	if client.Idempotent(req.RequestLine.Method) {
+		retries = lb.MaxRetries
+	}
+	if retries > 0 {
+		// The body has to be at hand to send it again
+		_, err := req.ReadBody()
+		if err != nil {
+			log.Println(err)
+			writeStatus(w, server.BodyErrorStatus(err))
+			return
+		}
+	}
+
+	tried := map[*backend]bool{}
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		b := lb.pick(req, tried)
+		if b == nil {
+			break
+		}
+		tried[b] = true
+
+		b.active.Add(1)
+		resp, err := lb.proxyFor(b).roundTrip(req, req.BodyReader())
+		if err != nil {
+			b.active.Add(-1)
+			log.Println(err)
+			var readErr *client.RequestBodyError
+			if errors.As(err, &readErr) {
+				writeStatus(w, server.BodyErrorStatus(readErr.Err))
+				return
+			}
+			// A request abandoned by its own context says nothing about
+			// the backend, and isn't worth retrying
+			if req.Context().Err() != nil {
+				writeGatewayError(w, err)
+				return
+			}
+			lb.recordResult(b, false)
+			lastErr = err
+			continue
+		}
+
+		lb.recordResult(b, resp.StatusCode < 502 || resp.StatusCode > 504)
+		relay(w, req, resp)
+		resp.Close()
+		b.active.Add(-1)
+		return
+	}
+
+	if lastErr == nil {
+		writeStatus(w, response.Code503)
+		return
+	}
+	writeGatewayError(w, lastErr)
+}
+
+// StartHealthChecks requests path from every upstream each interval, taking
+// any that don't answer with a 2xx or 3xx out of rotation until they do.
+// Close stops the checks
+func (lb *LoadBalancer) StartHealthChecks(path string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			lb.checkHealth(path)
+			select {
+			case <-ticker.C:
+			case <-lb.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (lb *LoadBalancer) Close() {
+	lb.stopOnce.Do(func() { close(lb.stop) })
+}
+
+func (lb *LoadBalancer) checkHealth(path string) {
+	var wg sync.WaitGroup
+	for _, b := range lb.backends {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+			healthy := lb.probe(b, path)
+			b.mu.Lock()
+			b.unhealthy = !healthy
+			b.mu.Unlock()
+		}(b)
+	}
+	wg.Wait()
+}
+
+func (lb *LoadBalancer) probe(b *backend, path string) bool {
+	p := lb.proxyFor(b)
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: path, HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	resp, err := p.roundTrip(req, req.BodyReader())
+	if err != nil {
+		return false
+	}
+	defer resp.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode <= 399
+}
+
+// proxyFor returns a proxy to one upstream, sharing the balancer's
+// connection pool
+func (lb *LoadBalancer) proxyFor(b *backend) *ReverseProxy {
+	lb.transportOnce.Do(func() {
+		lb.transport = &client.Client{DialTimeout: lb.DialTimeout, Timeout: lb.ResponseTimeout}
+	})
+	return &ReverseProxy{
+		Upstream:        b.url,
+		StripPrefix:     lb.StripPrefix,
+		DialTimeout:     lb.DialTimeout,
+		ResponseTimeout: lb.ResponseTimeout,
+		transport:       lb.transport,
+	}
+}
+
+// recordResult tracks consecutive failures for passive ejection
+func (lb *LoadBalancer) recordResult(b *backend, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if lb.MaxFailures > 0 && b.failures >= lb.MaxFailures {
+		b.ejectedUntil = time.Now().Add(lb.EjectionTime)
+		b.failures = 0
+	}
+}
+
+func (b *backend) available(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.unhealthy && !now.Before(b.ejectedUntil)
+}
+
+// pick chooses an available upstream not already tried for this request,
+// or nil if there's none left
+func (lb *LoadBalancer) pick(req *request.Request, tried map[*backend]bool) *backend {
+	now := time.Now()
+	usable := func(b *backend) bool {
+		return !tried[b] && b.available(now)
+	}
+
+	switch lb.Policy {
+	case ConsistentHash:
+		key := ""
+		if lb.HashKey != nil {
+			key = lb.HashKey(req)
+		} else if req.RemoteAddr != "" {
+			key, _, _ = net.SplitHostPort(req.RemoteAddr)
+		}
+		h := hashKey(key)
+		start := sort.Search(len(lb.ring), func(i int) bool {
+			return lb.ring[i].hash >= h
+		})
+		for i := 0; i < len(lb.ring); i++ {
+			b := lb.ring[(start+i)%len(lb.ring)].backend
+			if usable(b) {
+				return b
+			}
+		}
+		return nil
+	case LeastConnections:
+		// Ties go round-robin so idle upstreams share the load
+		offset := int(lb.next.Add(1) - 1)
+		var best *backend
+		for i := range lb.backends {
+			b := lb.backends[(offset+i)%len(lb.backends)]
+			if usable(b) && (best == nil || b.active.Load() < best.active.Load()) {
+				best = b
+			}
+		}
+		return best
+	default:
+		offset := int(lb.next.Add(1) - 1)
+		for i := range lb.backends {
+			b := lb.backends[(offset+i)%len(lb.backends)]
+			if usable(b) {
+				return b
+			}
+		}
+		return nil
+	}
+}
+
+func hashKey(key string) uint32 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/jms-guy/httpfromtcp/internal/servertest"
+	"github.com/jms-guy/httpfromtcp/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func namedUpstream(t *testing.T, name string) string {
+	return failingUpstream(t, name, nil)
+}
+
+// failingUpstream answers with its name, or with a 503 while failing is set
+func failingUpstream(t *testing.T, name string, failing *atomic.Bool) string {
+	return servertest.Serve(t, func(w *response.Writer, req *request.Request) {
+		w.Body = []byte(name)
+		if req.RequestLine.RequestTarget == "/health" && name == "sick" {
+			w.Status = response.Code500
+		}
+		if failing != nil && failing.Load() {
+			w.Status = response.Code503
+		}
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": strconv.Itoa(len(w.Body))})
+		w.WriteBody()
+	})
+}
+
+// balance sends a request from remoteAddr through lb, returning the body of
+// a 200 response or else the whole response
+func balance(t *testing.T, lb *LoadBalancer, method, remoteAddr string) string {
+	req := servertest.NewRequest(method, "/", headers.Headers{"host": "example.com"})
+	req.RemoteAddr = remoteAddr
+	out := servertest.Record(lb.Handle, req)
+	if strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n") {
+		// Bodies come back as a single chunk, return just its data
+		lines := strings.Split(out, "\r\n")
+		for i, line := range lines {
+			if line == "" && i+2 < len(lines) {
+				return lines[i+2]
+			}
+		}
+	}
+	return out
+}
+
+func TestLoadBalancer(t *testing.T) {
+	a, b, c := namedUpstream(t, "a"), namedUpstream(t, "b"), namedUpstream(t, "c")
+
+	// Test: Round-robin cycles through the pool in order
+	lb, err := NewLoadBalancer(RoundRobin, a, b, c)
+	require.NoError(t, err)
+	got := []string{}
+	for i := 0; i < 6; i++ {
+		got = append(got, balance(t, lb, "GET", "10.0.0.1:1000"))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, got)
+
+	// Test: Least-connections prefers the upstream with the fewest in flight
+	entered, release := make(chan struct{}), make(chan struct{})
+	slow := servertest.Serve(t, func(w *response.Writer, req *request.Request) {
+		entered <- struct{}{}
+		<-release
+		w.Body = []byte("slow")
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": "4"})
+		w.WriteBody()
+	})
+	lb, err = NewLoadBalancer(LeastConnections, slow, b)
+	require.NoError(t, err)
+	inFlight := make(chan string)
+	go func() { inFlight <- balance(t, lb, "GET", "10.0.0.1:1000") }()
+	<-entered
+	assert.Equal(t, "b", balance(t, lb, "GET", "10.0.0.1:1000"))
+	assert.Equal(t, "b", balance(t, lb, "GET", "10.0.0.1:1000"))
+	close(release)
+	assert.Equal(t, "slow", <-inFlight)
+
+	// Test: Consistent hashing keeps a client on one upstream, and only
+	// clients of an ejected upstream move
+	failing := &atomic.Bool{}
+	b = failingUpstream(t, "b", failing)
+	lb, err = NewLoadBalancer(ConsistentHash, a, b, c)
+	require.NoError(t, err)
+	lb.MaxFailures = 1
+	before := map[string]string{}
+	for i := 0; i < 30; i++ {
+		addr := "10.0.0." + strconv.Itoa(i) + ":1000"
+		before[addr] = balance(t, lb, "GET", addr)
+		assert.Equal(t, before[addr], balance(t, lb, "GET", addr))
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, slices.Compact(slices.Sorted(maps.Values(before))))
+	failing.Store(true)
+	for addr, name := range before {
+		if name == "b" {
+			assert.Contains(t, balance(t, lb, "GET", addr), "503 Service Unavailable")
+			break
+		}
+	}
+	for addr, name := range before {
+		if name != "b" {
+			assert.Equal(t, name, balance(t, lb, "GET", addr))
+		} else {
+			assert.NotEqual(t, "b", balance(t, lb, "GET", addr))
+		}
+	}
+
+	// Test: Idempotent requests are retried past an unreachable upstream,
+	// which gets ejected once it keeps failing
+	dead := "http://" + testutil.ClosedAddr(t)
+	lb, err = NewLoadBalancer(RoundRobin, dead, a)
+	require.NoError(t, err)
+	lb.MaxFailures = 2
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "a", balance(t, lb, "GET", "10.0.0.1:1000"))
+	}
+	lb.MaxRetries = 0
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "a", balance(t, lb, "GET", "10.0.0.1:1000"))
+	}
+
+	// Test: Non-idempotent requests aren't retried
+	lb, err = NewLoadBalancer(RoundRobin, dead, a)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(balance(t, lb, "POST", "10.0.0.1:1000"), "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: Upstreams failing the health check are taken out of rotation
+	sick := namedUpstream(t, "sick")
+	lb, err = NewLoadBalancer(RoundRobin, sick, a)
+	require.NoError(t, err)
+	lb.MaxRetries = 0
+	lb.StartHealthChecks("/health", time.Hour)
+	defer lb.Close()
+	require.Eventually(t, func() bool {
+		for i := 0; i < 4; i++ {
+			if balance(t, lb, "GET", "10.0.0.1:1000") != "a" {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	// Test: No upstream left to try answers 503
+	lb, err = NewLoadBalancer(RoundRobin, sick)
+	require.NoError(t, err)
+	lb.StartHealthChecks("/health", time.Hour)
+	defer lb.Close()
+	require.Eventually(t, func() bool {
+		return strings.HasPrefix(balance(t, lb, "GET", "10.0.0.1:1000"), "HTTP/1.1 503 Service Unavailable\r\n")
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = NewLoadBalancer(RoundRobin)
+	assert.Error(t, err)
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/client"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+)
+
+const defaultIdleTimeout = 5 * time.Minute
+
+// ForwardProxy serves clients that use the server as their HTTP proxy.
+// CONNECT requests open a tunnel to the host and port they name, and
+// requests with an absolute URL as their target are forwarded to that URL's
+// origin. DialTimeout bounds connecting and ResponseTimeout bounds getting a
+// forwarded response head back. A tunnel is closed once no bytes have
+// crossed it in either direction for IdleTimeout
+type ForwardProxy struct {
+	DialTimeout     time.Duration
+	ResponseTimeout time.Duration
+	IdleTimeout     time.Duration
+	transport       *client.Client
+	transportOnce   sync.Once
+}
+
+func NewForwardProxy() *ForwardProxy {
+	return &ForwardProxy{DialTimeout: defaultDialTimeout, ResponseTimeout: defaultResponseTimeout, IdleTimeout: defaultIdleTimeout}
+}
+
+func (p *ForwardProxy) Handle(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "CONNECT" {
+		p.tunnel(w, req)
+		return
+	}
+
+	u, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeStatus(w, response.Code400)
+		return
+	}
+	p.transportOnce.Do(func() {
+		p.transport = &client.Client{DialTimeout: p.DialTimeout, Timeout: p.ResponseTimeout}
+	})
+	origin := &ReverseProxy{
+		Upstream:        &url.URL{Scheme: u.Scheme, Host: u.Host},
+		DialTimeout:     p.DialTimeout,
+		ResponseTimeout: p.ResponseTimeout,
+		transport:       p.transport,
+	}
+	origin.Handle(w, req)
+}
+
+// tunnel dials the authority a CONNECT request names and, once connected,
+// takes the client connection over to copy bytes between the two
+func (p *ForwardProxy) tunnel(w *response.Writer, req *request.Request) {
+	authority := req.RequestLine.RequestTarget
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil || host == "" || port == "" {
+		writeStatus(w, response.Code400)
+		return
+	}
+
+	dialer := &net.Dialer{Timeout: p.DialTimeout}
+	upstream, err := dialer.DialContext(req.Context(), "tcp", authority)
+	if err != nil {
+		log.Println(err)
+		writeGatewayError(w, err)
+		return
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		log.Println(err)
+		upstream.Close()
+		writeStatus(w, response.Code500)
+		return
+	}
+	_, err = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	if err == nil && len(buffered) > 0 {
+		_, err = upstream.Write(buffered)
+	}
+	if err != nil {
+		log.Println(err)
+		conn.Close()
+		upstream.Close()
+		return
+	}
+
+	// The tunnel outlives the server's watch on the client, but still ends
+	// with the request's context
+	stop := context.AfterFunc(req.Context(), func() {
+		conn.Close()
+		upstream.Close()
+	})
+	defer stop()
+	splice(conn, upstream, p.IdleTimeout)
+}
+
+// splice copies bytes both ways between a and b until both sides have
+// finished sending, either fails, or nothing crosses in either direction
+// for idle, then closes both
+func splice(a, b net.Conn, idle time.Duration) {
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+
+	errs := make(chan error, 2)
+	go func() { errs <- pipe(a, b, idle, &lastActive) }()
+	go func() { errs <- pipe(b, a, idle, &lastActive) }()
+
+	// A clean end to one direction leaves the other to finish on its own
+	err := <-errs
+	if err != nil {
+		a.Close()
+		b.Close()
+	}
+	<-errs
+	a.Close()
+	b.Close()
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// pipe copies from src to dst, passing an end of stream on as a half close
+func pipe(dst, src net.Conn, idle time.Duration, lastActive *atomic.Int64) error {
+	buf := make([]byte, 32*1024)
+	for {
+		if idle > 0 {
+			src.SetReadDeadline(time.Unix(0, lastActive.Load()).Add(idle))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			lastActive.Store(time.Now().UnixNano())
+			if idle > 0 {
+				dst.SetWriteDeadline(time.Now().Add(idle))
+			}
+			_, writeErr := dst.Write(buf[:n])
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+		switch {
+		case err == nil:
+		case err == io.EOF:
+			cw, ok := dst.(closeWriter)
+			if !ok {
+				return err
+			}
+			return cw.CloseWrite()
+		case errors.Is(err, os.ErrDeadlineExceeded) && time.Since(time.Unix(0, lastActive.Load())) < idle:
+			// The other direction has been busy, so the tunnel isn't idle
+		default:
+			return err
+		}
+	}
+}
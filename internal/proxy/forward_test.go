@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/jms-guy/httpfromtcp/internal/servertest"
+	"github.com/jms-guy/httpfromtcp/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardProxy(t *testing.T) {
+	fp := NewForwardProxy()
+	fp.IdleTimeout = 100 * time.Millisecond
+	proxyAddr := strings.TrimPrefix(servertest.Serve(t, fp.Handle), "http://")
+	// Writes back whatever it reads
+	echo := testutil.Listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+
+	dial := func(raw string) net.Conn {
+		conn, err := net.Dial("tcp", proxyAddr)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+		return conn
+	}
+	established := "HTTP/1.1 200 Connection Established\r\n\r\n"
+
+	// Test: CONNECT opens a tunnel carrying bytes both ways
+	conn := dial("CONNECT " + echo + " HTTP/1.1\r\nHost: " + echo + "\r\n\r\n")
+	reply := make([]byte, len(established))
+	_, err := io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, established, string(reply))
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	echoed := make([]byte, 4)
+	_, err = io.ReadFull(conn, echoed)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(echoed))
+
+	// Test: A tunnel nothing crosses is closed after the idle timeout
+	start := time.Now()
+	_, err = conn.Read(echoed)
+	assert.Equal(t, io.EOF, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// Test: Bytes sent along with the CONNECT request go through the tunnel
+	conn = dial("CONNECT " + echo + " HTTP/1.1\r\nHost: " + echo + "\r\n\r\neager")
+	reply = make([]byte, len(established)+len("eager"))
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, established+"eager", string(reply))
+
+	// Test: A half close is passed on, letting the other direction finish
+	conn.Write([]byte("last"))
+	conn.(*net.TCPConn).CloseWrite()
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "last", string(rest))
+
+	// Test: An unreachable destination answers 502
+	unreachable := testutil.ClosedAddr(t)
+	conn = dial("CONNECT " + unreachable + " HTTP/1.1\r\nHost: " + unreachable + "\r\n\r\n")
+	resp, err := response.NewReader(conn).ReadHead("CONNECT")
+	require.NoError(t, err)
+	assert.Equal(t, response.Code502, resp.StatusCode)
+
+	// Test: A CONNECT target that isn't host:port answers 400
+	conn = dial("CONNECT /path HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	resp, err = response.NewReader(conn).ReadHead("CONNECT")
+	require.NoError(t, err)
+	assert.Equal(t, response.Code400, resp.StatusCode)
+
+	// Test: Absolute-form requests are forwarded to their origin
+	origin := servertest.Serve(t, func(w *response.Writer, req *request.Request) {
+		w.Body = []byte(fmt.Sprintf("%s %s host=%s proxy=%s", req.RequestLine.Method, req.RequestLine.RequestTarget,
+			req.Headers.Get("host"), req.Headers.Get("proxy-connection")))
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": fmt.Sprint(len(w.Body))})
+		w.WriteBody()
+	})
+	originHost := strings.TrimPrefix(origin, "http://")
+	conn = dial("GET " + origin + "/page?q=1 HTTP/1.1\r\nHost: " + originHost + "\r\nProxy-Connection: keep-alive\r\n\r\n")
+	resp, err = response.ResponseFromReader(conn)
+	require.NoError(t, err)
+	assert.Equal(t, response.Code200, resp.StatusCode)
+	assert.Equal(t, "GET /page?q=1 host="+originHost+" proxy=", string(resp.Body))
+
+	// Test: Requests in origin form have nowhere to go and answer 400
+	conn = dial("GET /page HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	resp, err = response.NewReader(conn).ReadHead("GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.Code400, resp.StatusCode)
+}
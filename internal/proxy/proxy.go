@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/client"
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/jms-guy/httpfromtcp/internal/server"
+)
+
+const (
+	defaultDialTimeout     = 10 * time.Second
+	defaultResponseTimeout = 30 * time.Second
+)
+
+// Fields that only mean something on a single connection and must not be
+// passed on by a proxy, RFC 9110 section 7.6.1
+var hopByHop = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ReverseProxy forwards requests to an upstream HTTP server and relays its
+// responses back. StripPrefix is removed from the request path before it's
+// joined onto the upstream URL's path. DialTimeout bounds connecting to the
+// upstream and ResponseTimeout bounds sending the request and getting the
+// response head back; exceeding either, or the request's context timing
+// out, answers 504, while any other failure to get a response answers 502.
+// The upstream request is abandoned if the client goes away. Connections
+// to the upstream are pooled, using the timeouts set when the first request
+// is proxied
+type ReverseProxy struct {
+	Upstream        *url.URL
+	StripPrefix     string
+	DialTimeout     time.Duration
+	ResponseTimeout time.Duration
+	transport       *client.Client
+	transportOnce   sync.Once
+}
+
+func New(upstream string) (*ReverseProxy, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid upstream url: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("error: upstream must be an absolute http or https url")
+	}
+
+	return &ReverseProxy{Upstream: u, DialTimeout: defaultDialTimeout, ResponseTimeout: defaultResponseTimeout}, nil
+}
+
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	resp, err := p.roundTrip(req, req.BodyReader())
+	if err != nil {
+		log.Println(err)
+		writeRoundTripError(w, err)
+		return
+	}
+	defer resp.Close()
+
+	relay(w, req, resp)
+}
+
+// roundTrip sends the request upstream and reads back the response head,
+// leaving the caller to read the body and close the response
+func (p *ReverseProxy) roundTrip(req *request.Request, body io.Reader) (*client.Response, error) {
+	return p.client().DoWithBody(p.outboundRequest(req), body)
+}
+
+func (p *ReverseProxy) client() *client.Client {
+	p.transportOnce.Do(func() {
+		if p.transport == nil {
+			p.transport = &client.Client{DialTimeout: p.DialTimeout, Timeout: p.ResponseTimeout}
+		}
+	})
+	return p.transport
+}
+
+// outboundRequest builds the request sent upstream: the target rewritten
+// onto the upstream URL, hop-by-hop fields dropped and forwarding fields added
+func (p *ReverseProxy) outboundRequest(req *request.Request) *request.Request {
+	h := headers.NewHeaders()
+	for key, val := range req.Headers {
+		h[key] = val
+	}
+	removeHopByHop(h)
+
+	clientIP := ""
+	if req.RemoteAddr != "" {
+		clientIP, _, _ = net.SplitHostPort(req.RemoteAddr)
+	}
+	originalHost := req.Headers.Get("host")
+
+	if clientIP != "" {
+		if prior := h.Get("x-forwarded-for"); prior != "" {
+			h["x-forwarded-for"] = prior + ", " + clientIP
+		} else {
+			h["x-forwarded-for"] = clientIP
+		}
+	}
+	forwarded := []string{}
+	if clientIP != "" {
+		forwarded = append(forwarded, "for="+forwardedNode(clientIP))
+	}
+	if originalHost != "" {
+		forwarded = append(forwarded, "host="+quoteIfNeeded(originalHost))
+		h["x-forwarded-host"] = originalHost
+	}
+	forwarded = append(forwarded, "proto=http")
+	if prior := h.Get("forwarded"); prior != "" {
+		h["forwarded"] = prior + ", " + strings.Join(forwarded, ";")
+	} else {
+		h["forwarded"] = strings.Join(forwarded, ";")
+	}
+	h["x-forwarded-proto"] = "http"
+
+	h["host"] = p.Upstream.Host
+
+	outbound := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        req.RequestLine.Method,
+			RequestTarget: p.Upstream.Scheme + "://" + p.Upstream.Host + p.target(req.RequestLine.RequestTarget),
+			HttpVersion:   "1.1",
+		},
+		Headers: h,
+	}
+	// The upstream request is abandoned along with the client's
+	return outbound.WithContext(req.Context())
+}
+
+func (p *ReverseProxy) target(requestTarget string) string {
+	// A target in absolute form only contributes its path and query
+	if u, err := url.Parse(requestTarget); err == nil && u.IsAbs() {
+		requestTarget = u.RequestURI()
+	}
+	path, query, hasQuery := strings.Cut(requestTarget, "?")
+	if p.StripPrefix != "" && strings.HasPrefix(path, p.StripPrefix) {
+		path = strings.TrimPrefix(path, p.StripPrefix)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+
+	target := strings.TrimSuffix(p.Upstream.EscapedPath(), "/") + path
+	if target == "" {
+		target = "/"
+	}
+	if hasQuery {
+		target += "?" + query
+	}
+	return target
+}
+
+// removeHopByHop drops the fixed hop-by-hop fields and any others the
+// Connection field names
+func removeHopByHop(h headers.Headers) {
+	for _, option := range strings.Split(h.Get("connection"), ",") {
+		if option = strings.TrimSpace(option); option != "" {
+			h.Del(option)
+		}
+	}
+	for _, name := range hopByHop {
+		h.Del(name)
+	}
+}
+
+// forwardedNode formats an address for the Forwarded field, where IPv6
+// addresses have to be bracketed and quoted, RFC 7239 section 6
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+func quoteIfNeeded(value string) string {
+	if headers.IsToken(value) {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// relay writes the upstream response back to the client, re-framing any body
+// as chunked so it can be streamed through and carry the upstream's trailers
+func relay(w *response.Writer, req *request.Request, resp *client.Response) {
+	h := resp.Headers
+	declaredTrailers := h.Get("trailer")
+	removeHopByHop(h)
+
+	w.Status = resp.StatusCode
+	w.Reason = resp.Reason
+	for _, value := range resp.SetCookies {
+		w.AddSetCookie(value)
+	}
+	err := w.WriteStatusLine()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// With no body to stream the fields pass through as they are, so a
+	// Content-Length still describes the representation
+	if req.RequestLine.Method == "HEAD" || resp.StatusCode == 204 || resp.StatusCode == 304 {
+		err = w.WriteHeaders(h)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	h.Del("Content-Length")
+	h["Transfer-Encoding"] = "chunked"
+	if declaredTrailers != "" {
+		h["Trailer"] = declaredTrailers
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	body := resp.BodyReader()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			_, writeErr := w.WriteChunkedBody(buf[:n])
+			if writeErr != nil {
+				log.Println(writeErr)
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Leaving out the last chunk tells the client the body is
+			// incomplete, the headers have already gone out
+			log.Println(err)
+			return
+		}
+	}
+
+	_, err = w.WriteChunkedBodyDone()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = w.WriteTrailers(resp.Trailers)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// writeRoundTripError answers a request that never got a response from the
+// upstream
+func writeRoundTripError(w *response.Writer, err error) {
+	var readErr *client.RequestBodyError
+	if errors.As(err, &readErr) {
+		writeStatus(w, server.BodyErrorStatus(readErr.Err))
+		return
+	}
+	writeGatewayError(w, err)
+}
+
+func writeGatewayError(w *response.Writer, err error) {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		writeStatus(w, response.Code504)
+		return
+	}
+	writeStatus(w, response.Code502)
+}
+
+func writeStatus(w *response.Writer, status response.StatusCode) {
+	w.Status = status
+	w.WriteStatusLine()
+	w.WriteHeaders(headers.Headers{"Content-Length": "0"})
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/cookie"
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/jms-guy/httpfromtcp/internal/server"
+	"github.com/jms-guy/httpfromtcp/internal/servertest"
+	"github.com/jms-guy/httpfromtcp/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseProxy(t *testing.T) {
+	upstream := servertest.Serve(t, func(w *response.Writer, req *request.Request) {
+		body, _ := req.ReadBody()
+		w.Body = []byte(fmt.Sprintf("%s %s\nhost=%s\nxff=%s\nforwarded=%s\nsecret=%s\nkeep=%s\nbody=%s",
+			req.RequestLine.Method, req.RequestLine.RequestTarget, req.Headers.Get("host"),
+			req.Headers.Get("x-forwarded-for"), req.Headers.Get("forwarded"),
+			req.Headers.Get("x-secret"), req.Headers.Get("keep-alive"), body))
+		w.WriteStatusLine()
+		w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Expires: time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)})
+		w.SetCookie(&cookie.Cookie{Name: "b", Value: "2"})
+		w.WriteHeaders(headers.Headers{
+			"Content-Type":      "text/plain",
+			"Transfer-Encoding": "chunked",
+			"Trailer":           "X-Checksum",
+			"Keep-Alive":        "timeout=5",
+		})
+		w.WriteChunkedBody(w.Body)
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.Headers{"X-Checksum": "abc"})
+	})
+
+	// Test: Request is rewritten and forwarded, trailers come back
+	p, err := New(upstream)
+	require.NoError(t, err)
+	p.StripPrefix = "/api"
+	req := servertest.ReadRequest(t, "POST /api/items?x=1 HTTP/1.1\r\nHost: example.com\r\nConnection: X-Secret\r\nX-Secret: hunter2\r\nKeep-Alive: timeout=5\r\nX-Forwarded-For: 198.51.100.1\r\nContent-Length: 5\r\n\r\nhello")
+	req.RemoteAddr = "203.0.113.7:51234"
+	out := servertest.Record(p.Handle, req)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "POST /items?x=1\n")
+	assert.Contains(t, out, "host="+strings.TrimPrefix(upstream, "http://")+"\n")
+	assert.Contains(t, out, "xff=198.51.100.1, 203.0.113.7\n")
+	assert.Contains(t, out, "forwarded=for=203.0.113.7;host=example.com;proto=http\n")
+	assert.Contains(t, out, "secret=\n")
+	assert.Contains(t, out, "keep=\n")
+	assert.Contains(t, out, "body=hello")
+	assert.NotContains(t, out, "Keep-Alive")
+	assert.Contains(t, out, "Trailer: X-Checksum\r\n")
+	assert.Contains(t, out, "Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nSet-Cookie: b=2\r\n")
+	assert.True(t, strings.HasSuffix(out, "0\r\nX-Checksum: abc\r\n\r\n"))
+
+	// Test: HEAD keeps the upstream's Content-Length
+	head := servertest.Serve(t, func(w *response.Writer, req *request.Request) {
+		w.Body = []byte("twelve bytes")
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": strconv.Itoa(len(w.Body))})
+		w.WriteBody()
+	})
+	p, err = New(head)
+	require.NoError(t, err)
+	out = servertest.Record(p.Handle, servertest.ReadRequest(t, "HEAD / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	assert.Contains(t, out, "Content-Length: 12\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+
+	// Test: Unreachable upstream answers 502
+	p, err = New("http://" + testutil.ClosedAddr(t))
+	require.NoError(t, err)
+	out = servertest.Record(p.Handle, servertest.ReadRequest(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: Malformed upstream response answers 502
+	garbage := testutil.Listen(t, func(conn net.Conn) {
+		conn.Write([]byte("nonsense\r\n\r\n"))
+		conn.Close()
+	})
+	p, err = New("http://" + garbage)
+	require.NoError(t, err)
+	out = servertest.Record(p.Handle, servertest.ReadRequest(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: A reason phrase the writer doesn't know is passed through
+	custom := testutil.Listen(t, func(conn net.Conn) {
+		conn.Write([]byte("HTTP/1.1 299 Custom Thing\r\nContent-Length: 0\r\n\r\n"))
+		conn.Close()
+	})
+	p, err = New("http://" + custom)
+	require.NoError(t, err)
+	out = servertest.Record(p.Handle, servertest.ReadRequest(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 299 Custom Thing\r\n"))
+
+	// Test: A body left unread by the server is streamed to the upstream as
+	// it arrives
+	firstHalf := make(chan struct{})
+	streaming := testutil.Listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		reader := request.NewReader(conn)
+		req, err := reader.ReadHead()
+		if err != nil {
+			return
+		}
+		half := make([]byte, 5)
+		body := req.BodyReader()
+		if _, err := io.ReadFull(body, half); err != nil {
+			return
+		}
+		close(firstHalf)
+		rest, _ := io.ReadAll(body)
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s%s", len(half)+len(rest), half, rest)
+	})
+	p, err = New("http://" + streaming)
+	require.NoError(t, err)
+	proxied := servertest.ServeWithConfig(t, p.Handle, server.Config{StreamBodies: true})
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxied, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 10\r\n\r\nfirst"))
+	select {
+	case <-firstHalf:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream didn't get the body before it was sent in full")
+	}
+	conn.Write([]byte("later"))
+	resp, err := response.ResponseFromReader(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "firstlater", string(resp.Body))
+
+	// Test: A chunked body is refused with 411 rather than forwarded empty
+	conn, err = net.Dial("tcp", strings.TrimPrefix(proxied, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+	resp, err = response.NewReader(conn).ReadHead("POST")
+	require.NoError(t, err)
+	assert.Equal(t, response.Code411, resp.StatusCode)
+
+	// Test: Upstream that never answers times out with 504
+	silent := testutil.Listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		time.Sleep(time.Second)
+	})
+	p, err = New("http://" + silent)
+	require.NoError(t, err)
+	p.ResponseTimeout = 50 * time.Millisecond
+	out = servertest.Record(p.Handle, servertest.ReadRequest(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 504 Gateway Timeout\r\n"))
+
+	// Test: The upstream request is abandoned once the client goes away
+	abandoned := make(chan error, 1)
+	waiting := servertest.Serve(t, func(w *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			abandoned <- req.Context().Err()
+		case <-time.After(5 * time.Second):
+			abandoned <- nil
+		}
+	})
+	p, err = New(waiting)
+	require.NoError(t, err)
+	req = servertest.ReadRequest(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(30*time.Millisecond, cancel)
+	p.Handle(&response.Writer{ResponseWriter: &bytes.Buffer{}, Request: req}, req.WithContext(ctx))
+	assert.Equal(t, context.Canceled, <-abandoned)
+
+	// Test: Only absolute http and https upstreams are accepted
+	_, err = New("ftp://example.com")
+	assert.Error(t, err)
+	_, err = New("/relative")
+	assert.Error(t, err)
+}
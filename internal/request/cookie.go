@@ -0,0 +1,20 @@
+package request
+
+import (
+	"fmt"
+
+	"github.com/jms-guy/httpfromtcp/internal/cookie"
+)
+
+func (r *Request) Cookies() []*cookie.Cookie {
+	return cookie.Parse(r.Headers.Get("cookie"))
+}
+
+func (r *Request) Cookie(name string) (*cookie.Cookie, error) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("error: no cookie named %q", name)
+}
@@ -0,0 +1,91 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Guards against small compressed bodies that expand enormously
+const defaultMaxDecompressedSize = 10 << 20
+
+var (
+	ErrUnsupportedEncoding = errors.New("error: unsupported content-encoding")
+	ErrBodyTooLarge        = errors.New("error: decompressed body exceeds size limit")
+)
+
+// decodeBody undoes the codings listed in Content-Encoding, last applied
+// first, replacing the body and dropping the header once it's decoded
+func (r *Request) decodeBody() error {
+	contentEncoding := r.Headers.Get("content-encoding")
+	if contentEncoding == "" {
+		return nil
+	}
+	limit := int64(defaultMaxDecompressedSize)
+	if r.reader != nil && r.reader.MaxDecompressedSize > 0 {
+		limit = r.reader.MaxDecompressedSize
+	}
+
+	codings := strings.Split(contentEncoding, ",")
+	body := r.Body
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		if coding == "identity" || coding == "" {
+			continue
+		}
+		decoded, err := decode(coding, body, limit)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+
+	r.Body = body
+	r.Headers.Del("Content-Encoding")
+	r.Headers.Del("Content-Length")
+	r.Headers["content-length"] = strconv.Itoa(len(body))
+
+	return nil
+}
+
+func decode(coding string, body []byte, limit int64) ([]byte, error) {
+	var decoder io.Reader
+	switch coding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("error: malformed gzip body: %s", err)
+		}
+		defer gz.Close()
+		decoder = gz
+	case "deflate":
+		// deflate is meant to be zlib wrapped, but some clients send raw deflate
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			fr := flate.NewReader(bytes.NewReader(body))
+			defer fr.Close()
+			decoder = fr
+		} else {
+			defer zr.Close()
+			decoder = zr
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, coding)
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(decoder, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("error: malformed %s body: %s", coding, err)
+	}
+	if int64(len(decoded)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,100 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodedRequest(encoding string, body []byte) string {
+	return "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Encoding: " + encoding + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" +
+		string(body)
+}
+
+func TestRequestDecompress(t *testing.T) {
+	payload := strings.Repeat("hello world! ", 100)
+
+	// Test: Gzip body
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write([]byte(payload))
+	gz.Close()
+	reader := &chunkReader{data: encodedRequest("gzip", buf.Bytes()), numBytesPerRead: 7}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(r.Body))
+	assert.Equal(t, "", r.Headers.Get("content-encoding"))
+	assert.Equal(t, strconv.Itoa(len(payload)), r.Headers.Get("content-length"))
+
+	// Test: Zlib wrapped deflate body
+	buf = &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	zw.Write([]byte(payload))
+	zw.Close()
+	reader = &chunkReader{data: encodedRequest("deflate", buf.Bytes()), numBytesPerRead: 7}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(r.Body))
+
+	// Test: Raw deflate body
+	buf = &bytes.Buffer{}
+	fw, _ := flate.NewWriter(buf, flate.DefaultCompression)
+	fw.Write([]byte(payload))
+	fw.Close()
+	reader = &chunkReader{data: encodedRequest("deflate", buf.Bytes()), numBytesPerRead: 7}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(r.Body))
+
+	// Test: Unsupported encoding
+	reader = &chunkReader{data: encodedRequest("br", []byte("whatever")), numBytesPerRead: 7}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUnsupportedEncoding))
+
+	// Test: Malformed gzip body, which keeps failing on later reads
+	reader = &chunkReader{data: encodedRequest("gzip", []byte("not gzip at all")), numBytesPerRead: 7}
+	r, err = RequestFromReader(reader)
+	require.Error(t, err)
+	_, again := r.ReadBody()
+	assert.Equal(t, err, again)
+	_, err = io.ReadAll(r.BodyReader())
+	assert.Equal(t, again, err)
+
+	// Test: Streaming an encoded body gives the decoded body
+	buf = &bytes.Buffer{}
+	gz = gzip.NewWriter(buf)
+	gz.Write([]byte(payload))
+	gz.Close()
+	r, err = NewReader(&chunkReader{data: encodedRequest("gzip", buf.Bytes()), numBytesPerRead: 7}).ReadHead()
+	require.NoError(t, err)
+	streamed, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(streamed))
+
+	// Test: Decompressed size limit
+	buf = &bytes.Buffer{}
+	gz = gzip.NewWriter(buf)
+	gz.Write(bytes.Repeat([]byte{0}, 1<<20))
+	gz.Close()
+	rr := NewReader(&chunkReader{data: encodedRequest("gzip", buf.Bytes()), numBytesPerRead: 1024})
+	rr.MaxDecompressedSize = 1 << 10
+	r, err = rr.ReadHead()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrBodyTooLarge))
+}
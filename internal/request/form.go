@@ -0,0 +1,428 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+)
+
+const defaultMaxMemory = 32 << 20
+
+type Form struct {
+	Values map[string][]string
+	Files  map[string][]*FormFile
+}
+
+type FormFile struct {
+	Filename string
+	Headers  headers.Headers
+	Size     int64
+	content  []byte
+	tmpPath  string
+}
+
+// Open returns the part's content, either from memory or from the temp file
+// it was spilled to when the form exceeded its memory threshold
+func (f *FormFile) Open() (io.ReadCloser, error) {
+	if f.tmpPath != "" {
+		return os.Open(f.tmpPath)
+	}
+	return io.NopCloser(bytes.NewReader(f.content)), nil
+}
+
+// RemoveAll deletes any temp files created while parsing the form
+func (f *Form) RemoveAll() error {
+	var firstErr error
+	for _, files := range f.Files {
+		for _, file := range files {
+			if file.tmpPath == "" {
+				continue
+			}
+			err := os.Remove(file.tmpPath)
+			if err != nil && !os.IsNotExist(err) && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+
+	mediaType, _, err := r.mediaType()
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		_, err = r.ReadBody()
+		if err != nil {
+			return err
+		}
+		values, err := url.ParseQuery(string(r.Body))
+		if err != nil {
+			return fmt.Errorf("error: malformed urlencoded form: %s", err)
+		}
+		r.Form = &Form{Values: values, Files: make(map[string][]*FormFile)}
+		return nil
+	case "multipart/form-data":
+		return r.ParseMultipartForm(defaultMaxMemory)
+	default:
+		return fmt.Errorf("error: unsupported form content type %q", mediaType)
+	}
+}
+
+// ParseMultipartForm parses a multipart/form-data body. Up to maxMemory bytes of
+// parts are kept in memory, file parts beyond that are written to temp files
+func (r *Request) ParseMultipartForm(maxMemory int64) error {
+	if r.Form != nil {
+		return nil
+	}
+
+	mediaType, params, err := r.mediaType()
+	if err != nil {
+		return err
+	}
+	if mediaType != "multipart/form-data" {
+		return fmt.Errorf("error: request is not multipart/form-data")
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return fmt.Errorf("error: multipart form has no boundary")
+	}
+
+	// Parsed as it comes off the connection, so a body too big for memory
+	// is never held in full
+	form, err := parseMultipart(r.BodyReader(), boundary, maxMemory)
+	if err != nil {
+		return err
+	}
+	r.Form = form
+
+	return nil
+}
+
+// FormValue returns the first value for key from the form body, falling back
+// to the query string when the body doesn't have one
+func (r *Request) FormValue(key string) string {
+	if r.Form == nil {
+		r.ParseForm()
+	}
+	if r.Form != nil {
+		if values := r.Form.Values[key]; len(values) > 0 {
+			return values[0]
+		}
+	}
+	if values := r.Query()[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Query parses the query string of the request target, ignoring any
+// malformed pairs
+func (r *Request) Query() url.Values {
+	_, rawQuery, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	values, _ := url.ParseQuery(rawQuery)
+	return values
+}
+
+func (r *Request) FormFile(key string) (*FormFile, error) {
+	if r.Form == nil {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+	}
+	if files := r.Form.Files[key]; len(files) > 0 {
+		return files[0], nil
+	}
+	return nil, fmt.Errorf("error: no file in form field %q", key)
+}
+
+func (r *Request) mediaType() (string, map[string]string, error) {
+	contentType := r.Headers.Get("content-type")
+	if contentType == "" {
+		return "", nil, fmt.Errorf("error: request has no content-type")
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil, fmt.Errorf("error: malformed content-type: %s", err)
+	}
+	return mediaType, params, nil
+}
+
+// maxPartHeaderSize bounds the fields of a single multipart part, which are
+// held in memory whatever the form's threshold
+const maxPartHeaderSize = 16 << 10
+
+// multipartReader holds the part of a multipart body read so far but not yet
+// consumed, which past the part headers is never more than a delimiter's
+// length
+type multipartReader struct {
+	src   io.Reader
+	buf   []byte
+	chunk []byte
+	eof   bool
+}
+
+// fill reads more of the body onto buf, with io.ErrUnexpectedEOF once there
+// isn't any more
+func (m *multipartReader) fill() error {
+	for !m.eof {
+		n, err := m.src.Read(m.chunk)
+		m.buf = append(m.buf, m.chunk[:n]...)
+		if err == io.EOF {
+			m.eof = true
+		} else if err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// fillOr is fill with running out of body reported as malformed, while a
+// failure to read the body is passed on as it is
+func (m *multipartReader) fillOr(malformed string) error {
+	err := m.fill()
+	if err == io.ErrUnexpectedEOF {
+		return errors.New(malformed)
+	}
+	return err
+}
+
+func parseMultipart(body io.Reader, boundary string, maxMemory int64) (*Form, error) {
+	form := &Form{Values: make(map[string][]string), Files: make(map[string][]*FormFile)}
+	delimiter := []byte("--" + boundary)
+	partDelimiter := []byte("\r\n--" + boundary)
+	m := &multipartReader{src: body, chunk: make([]byte, 32*1024)}
+
+	// Skip the preamble, the first delimiter may or may not follow a CRLF
+	for len(m.buf) < len(delimiter) && !m.eof {
+		err := m.fill()
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+	}
+	if bytes.HasPrefix(m.buf, delimiter) {
+		m.buf = m.buf[len(delimiter):]
+	} else {
+		for {
+			idx := bytes.Index(m.buf, partDelimiter)
+			if idx != -1 {
+				m.buf = m.buf[idx+len(partDelimiter):]
+				break
+			}
+			if keep := len(partDelimiter) - 1; len(m.buf) > keep {
+				m.buf = m.buf[len(m.buf)-keep:]
+			}
+			err := m.fillOr("error: multipart body has no opening boundary")
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	remaining := maxMemory
+	for {
+		err := m.readPart(form, partDelimiter, &remaining)
+		if err == errFinalBoundary {
+			return form, nil
+		}
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+	}
+}
+
+var errFinalBoundary = errors.New("final multipart boundary")
+
+// readPart reads the part following a delimiter, adding it to the form, or
+// errFinalBoundary if the delimiter was the closing one
+func (m *multipartReader) readPart(form *Form, partDelimiter []byte, remaining *int64) error {
+	for len(m.buf) < 2 {
+		err := m.fillOr("error: malformed multipart boundary line")
+		if err != nil {
+			return err
+		}
+	}
+	if bytes.HasPrefix(m.buf, []byte("--")) {
+		return errFinalBoundary
+	}
+	for {
+		m.buf = bytes.TrimLeft(m.buf, " \t")
+		if len(m.buf) >= 2 {
+			break
+		}
+		err := m.fillOr("error: malformed multipart boundary line")
+		if err != nil {
+			return err
+		}
+	}
+	if !bytes.HasPrefix(m.buf, []byte("\r\n")) {
+		return fmt.Errorf("error: malformed multipart boundary line")
+	}
+	m.buf = m.buf[2:]
+
+	partHeaders := headers.NewHeaders()
+	for {
+		n, done, err := partHeaders.Parse(m.buf)
+		if err != nil {
+			return err
+		}
+		m.buf = m.buf[n:]
+		if done {
+			break
+		}
+		if n > 0 {
+			continue
+		}
+		if len(m.buf) > maxPartHeaderSize {
+			return fmt.Errorf("error: multipart part headers are too large")
+		}
+		err = m.fillOr("error: multipart part headers are incomplete")
+		if err != nil {
+			return err
+		}
+	}
+
+	part, err := form.newPart(partHeaders, remaining)
+	if err != nil {
+		return err
+	}
+	for {
+		if end := bytes.Index(m.buf, partDelimiter); end != -1 {
+			err = part.write(m.buf[:end])
+			m.buf = m.buf[end+len(partDelimiter):]
+			if err == nil {
+				err = part.close()
+			}
+			break
+		}
+		// Anything but a tail that could be the start of the delimiter
+		// is content
+		if keep := len(partDelimiter) - 1; len(m.buf) > keep {
+			err = part.write(m.buf[:len(m.buf)-keep])
+			m.buf = m.buf[len(m.buf)-keep:]
+			if err != nil {
+				break
+			}
+		}
+		err = m.fillOr("error: multipart body has no closing boundary")
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		part.abort()
+	}
+	return err
+}
+
+// formPart collects the content of one part as it's read. Values and files
+// are held in memory while they fit in what's left of the form's threshold,
+// after which a value is an error and a file is moved to a temp file
+type formPart struct {
+	form      *Form
+	name      string
+	file      *FormFile
+	value     []byte
+	tmp       *os.File
+	remaining *int64
+}
+
+func (f *Form) newPart(partHeaders headers.Headers, remaining *int64) (*formPart, error) {
+	disposition, params, err := mime.ParseMediaType(partHeaders.Get("content-disposition"))
+	if err != nil || disposition != "form-data" {
+		return nil, fmt.Errorf("error: multipart part is missing form-data disposition")
+	}
+	name := params["name"]
+	if name == "" {
+		return nil, fmt.Errorf("error: multipart part has no name")
+	}
+
+	part := &formPart{form: f, name: name, remaining: remaining}
+	if filename, isFile := params["filename"]; isFile {
+		part.file = &FormFile{Filename: filename, Headers: partHeaders}
+	}
+	return part, nil
+}
+
+func (p *formPart) write(content []byte) error {
+	if p.file == nil {
+		*p.remaining -= int64(len(content))
+		if *p.remaining < 0 {
+			return fmt.Errorf("error: multipart form values exceed memory limit")
+		}
+		p.value = append(p.value, content...)
+		return nil
+	}
+
+	p.file.Size += int64(len(content))
+	if p.tmp == nil && int64(len(content)) <= *p.remaining {
+		*p.remaining -= int64(len(content))
+		p.file.content = append(p.file.content, content...)
+		return nil
+	}
+	if p.tmp == nil {
+		tmp, err := os.CreateTemp("", "multipart-")
+		if err != nil {
+			return fmt.Errorf("error: creating temp file for multipart part: %s", err)
+		}
+		p.tmp = tmp
+		// What was held so far goes to the file, freeing its share of the
+		// threshold
+		*p.remaining += int64(len(p.file.content))
+		held := p.file.content
+		p.file.content = nil
+		_, err = p.tmp.Write(held)
+		if err != nil {
+			return fmt.Errorf("error: writing multipart part to temp file: %s", err)
+		}
+	}
+	_, err := p.tmp.Write(content)
+	if err != nil {
+		return fmt.Errorf("error: writing multipart part to temp file: %s", err)
+	}
+	return nil
+}
+
+// close adds the finished part to the form
+func (p *formPart) close() error {
+	if p.file == nil {
+		p.form.Values[p.name] = append(p.form.Values[p.name], string(p.value))
+		return nil
+	}
+	if p.tmp != nil {
+		err := p.tmp.Close()
+		if err != nil {
+			os.Remove(p.tmp.Name())
+			return fmt.Errorf("error: writing multipart part to temp file: %s", err)
+		}
+		p.file.tmpPath = p.tmp.Name()
+	}
+	p.form.Files[p.name] = append(p.form.Files[p.name], p.file)
+	return nil
+}
+
+// abort drops a part that failed part way, along with any temp file it had
+func (p *formPart) abort() {
+	if p.tmp != nil && p.file.tmpPath == "" {
+		p.tmp.Close()
+		os.Remove(p.tmp.Name())
+	}
+}
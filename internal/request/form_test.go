@@ -0,0 +1,105 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormParse(t *testing.T) {
+	// Test: Urlencoded form
+	body := "name=jms+guy&lang=go&lang=c%2B%2B"
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Type: application/x-www-form-urlencoded\r\n" +
+			"Content-Length: 33\r\n" +
+			"\r\n" +
+			body,
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "jms guy", r.FormValue("name"))
+	assert.Equal(t, []string{"go", "c++"}, r.Form.Values["lang"])
+	assert.Equal(t, "", r.FormValue("missing"))
+
+	// Test: Query values are found when the body doesn't have the key
+	r.RequestLine.RequestTarget = "/submit?name=other&page=2"
+	assert.Equal(t, "jms guy", r.FormValue("name"))
+	assert.Equal(t, "2", r.FormValue("page"))
+	r = &Request{RequestLine: RequestLine{Method: "GET", RequestTarget: "/search?q=go"}, Headers: map[string]string{}}
+	assert.Equal(t, "go", r.FormValue("q"))
+
+	// Test: Multipart form with value and file parts
+	body = "preamble\r\n" +
+		"--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n" +
+		"\r\n" +
+		"my upload\r\n" +
+		"--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"file\"; filename=\"notes.txt\"\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"line one\r\nline two\r\n" +
+		"--XyZ--\r\n"
+	r = &Request{Headers: map[string]string{"content-type": "multipart/form-data; boundary=XyZ"}, Body: []byte(body)}
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "my upload", r.FormValue("title"))
+	file, err := r.FormFile("file")
+	require.NoError(t, err)
+	assert.Equal(t, "notes.txt", file.Filename)
+	assert.Equal(t, "text/plain", file.Headers.Get("content-type"))
+	assert.Equal(t, int64(18), file.Size)
+	f, err := file.Open()
+	require.NoError(t, err)
+	content, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "line one\r\nline two", string(content))
+
+	// Test: File part over the memory threshold is written to a temp file
+	// as the body is read, without the body being held
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Type: multipart/form-data; boundary=XyZ\r\n" +
+			fmt.Sprintf("Content-Length: %d\r\n", len(body)) +
+			"\r\n" +
+			body,
+		numBytesPerRead: 3,
+	}
+	r, err = NewReader(reader).ReadHead()
+	require.NoError(t, err)
+	require.NoError(t, r.ParseMultipartForm(12))
+	assert.Empty(t, r.Body)
+	assert.Equal(t, "my upload", r.FormValue("title"))
+	file, err = r.FormFile("file")
+	require.NoError(t, err)
+	require.NotEmpty(t, file.tmpPath)
+	content, err = os.ReadFile(file.tmpPath)
+	require.NoError(t, err)
+	assert.Equal(t, "line one\r\nline two", string(content))
+	require.NoError(t, r.Form.RemoveAll())
+	_, err = os.Stat(file.tmpPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// Test: Multipart form without closing boundary
+	r = &Request{
+		Headers: map[string]string{"content-type": "multipart/form-data; boundary=XyZ"},
+		Body:    []byte("--XyZ\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nvalue"),
+	}
+	require.Error(t, r.ParseForm())
+
+	// Test: Multipart form without boundary parameter
+	r = &Request{Headers: map[string]string{"content-type": "multipart/form-data"}, Body: []byte(body)}
+	require.Error(t, r.ParseForm())
+
+	// Test: Unsupported content type
+	r = &Request{Headers: map[string]string{"content-type": "application/json"}, Body: []byte("{}")}
+	require.Error(t, r.ParseForm())
+}
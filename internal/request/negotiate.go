@@ -0,0 +1,174 @@
+package request
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AcceptSpec is one element of an Accept-style header, such as
+// text/html;level=1;q=0.8
+type AcceptSpec struct {
+	Value  string
+	Q      float64
+	Params map[string]string
+}
+
+// ParseAccept splits an Accept, Accept-Language, Accept-Charset or
+// Accept-Encoding value into its elements, ordered by descending q-value.
+// Elements with a malformed q-value are given q=0
+func ParseAccept(value string) []AcceptSpec {
+	specs := []AcceptSpec{}
+	for _, part := range strings.Split(value, ",") {
+		fields := strings.Split(part, ";")
+		spec := AcceptSpec{Value: strings.ToLower(strings.TrimSpace(fields[0])), Q: 1, Params: map[string]string{}}
+		if spec.Value == "" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			name, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok {
+				continue
+			}
+			name = strings.ToLower(strings.TrimSpace(name))
+			val = strings.Trim(strings.TrimSpace(val), `"`)
+			if name == "q" {
+				q, err := strconv.ParseFloat(val, 64)
+				if err != nil || q < 0 || q > 1 {
+					q = 0
+				}
+				spec.Q = q
+				continue
+			}
+			spec.Params[name] = val
+		}
+		specs = append(specs, spec)
+	}
+	sort.SliceStable(specs, func(i, j int) bool {
+		return specs[i].Q > specs[j].Q
+	})
+
+	return specs
+}
+
+// NegotiateContentType returns the offered media type the Accept header
+// ranks highest, preferring earlier offers on a tie, or "" if none are
+// acceptable
+func (r *Request) NegotiateContentType(offers ...string) string {
+	return r.negotiate("accept", offers, matchMediaType)
+}
+
+// NegotiateLanguage picks from offered language tags using Accept-Language,
+// where a range like en matches en-US
+func (r *Request) NegotiateLanguage(offers ...string) string {
+	return r.negotiate("accept-language", offers, matchLanguage)
+}
+
+func (r *Request) NegotiateCharset(offers ...string) string {
+	return r.negotiate("accept-charset", offers, matchCharset)
+}
+
+// VaryFields lists the request headers that negotiation has been based on,
+// which a response has to name in its Vary header
+func (r *Request) VaryFields() []string {
+	return r.varyFields
+}
+
+// A match function reports how specifically spec matches offer, with -1
+// meaning it doesn't match at all
+type matchFunc func(spec AcceptSpec, offer string) int
+
+func (r *Request) negotiate(field string, offers []string, match matchFunc) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	r.addVaryField(field)
+
+	value := r.Headers.Get(field)
+	if strings.TrimSpace(value) == "" {
+		return offers[0]
+	}
+	specs := ParseAccept(value)
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, spec := range specs {
+			s := match(spec, offer)
+			if s > specificity {
+				q, specificity = spec.Q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+func (r *Request) addVaryField(field string) {
+	for _, existing := range r.varyFields {
+		if existing == field {
+			return
+		}
+	}
+	r.varyFields = append(r.varyFields, field)
+}
+
+func matchMediaType(spec AcceptSpec, offer string) int {
+	offerType, offerParams, _ := strings.Cut(strings.ToLower(offer), ";")
+	mainType, subType, ok := strings.Cut(strings.TrimSpace(offerType), "/")
+	if !ok {
+		return -1
+	}
+	specMain, specSub, ok := strings.Cut(spec.Value, "/")
+	if !ok {
+		return -1
+	}
+
+	switch {
+	case specMain == "*" && specSub == "*":
+		return 0
+	case specMain == mainType && specSub == "*":
+		return 1
+	case specMain != mainType || specSub != subType:
+		return -1
+	}
+
+	// A range with parameters only matches offers carrying the same ones
+	params := map[string]string{}
+	for _, param := range strings.Split(offerParams, ";") {
+		name, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok {
+			params[strings.TrimSpace(name)] = strings.Trim(strings.TrimSpace(val), `"`)
+		}
+	}
+	for name, val := range spec.Params {
+		if params[name] != val {
+			return -1
+		}
+	}
+	return 2 + len(spec.Params)
+}
+
+func matchLanguage(spec AcceptSpec, offer string) int {
+	offer = strings.ToLower(offer)
+	if spec.Value == "*" {
+		return 0
+	}
+	if offer == spec.Value || strings.HasPrefix(offer, spec.Value+"-") {
+		return len(spec.Value)
+	}
+	return -1
+}
+
+func matchCharset(spec AcceptSpec, offer string) int {
+	if spec.Value == "*" {
+		return 0
+	}
+	if strings.EqualFold(spec.Value, offer) {
+		return 1
+	}
+	return -1
+}
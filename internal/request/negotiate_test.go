@@ -0,0 +1,75 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccept(t *testing.T) {
+	// Test: Ordered by q-value, parameters kept
+	specs := ParseAccept("text/plain;q=0.5, text/html;level=1, application/json;q=0.9, */*;q=0.1")
+	require.Len(t, specs, 4)
+	assert.Equal(t, "text/html", specs[0].Value)
+	assert.Equal(t, "1", specs[0].Params["level"])
+	assert.Equal(t, 1.0, specs[0].Q)
+	assert.Equal(t, "application/json", specs[1].Value)
+	assert.Equal(t, "text/plain", specs[2].Value)
+	assert.Equal(t, "*/*", specs[3].Value)
+	assert.Equal(t, 0.1, specs[3].Q)
+
+	// Test: Malformed q-value
+	specs = ParseAccept("gzip;q=abc, deflate")
+	require.Len(t, specs, 2)
+	assert.Equal(t, "deflate", specs[0].Value)
+	assert.Equal(t, 0.0, specs[1].Q)
+
+	// Test: Empty value
+	assert.Empty(t, ParseAccept(""))
+}
+
+func TestNegotiate(t *testing.T) {
+	r := &Request{Headers: headers.Headers{"accept": "text/html;q=0.8, application/json"}}
+
+	// Test: Highest q-value wins
+	assert.Equal(t, "application/json", r.NegotiateContentType("text/html", "application/json", "text/plain"))
+
+	// Test: Nothing acceptable
+	assert.Equal(t, "", r.NegotiateContentType("text/plain"))
+
+	// Test: Wildcards, more specific ranges take precedence
+	r = &Request{Headers: headers.Headers{"accept": "text/*;q=0.5, text/plain;q=0, */*;q=0.1"}}
+	assert.Equal(t, "text/html", r.NegotiateContentType("text/plain", "text/html", "application/json"))
+	assert.Equal(t, "application/json", r.NegotiateContentType("text/plain", "application/json"))
+
+	// Test: Parameters must match
+	r = &Request{Headers: headers.Headers{"accept": "text/html;level=1, text/html;level=2;q=0.4"}}
+	assert.Equal(t, "text/html;level=1", r.NegotiateContentType("text/html;level=2", "text/html;level=1"))
+	assert.Equal(t, "", r.NegotiateContentType("text/html"))
+
+	// Test: Ties go to the first offer
+	r = &Request{Headers: headers.Headers{"accept": "*/*"}}
+	assert.Equal(t, "text/plain", r.NegotiateContentType("text/plain", "text/html"))
+
+	// Test: Missing header accepts the first offer
+	r = &Request{Headers: headers.NewHeaders()}
+	assert.Equal(t, "text/html", r.NegotiateContentType("text/html", "application/json"))
+
+	// Test: Language prefixes
+	r = &Request{Headers: headers.Headers{"accept-language": "fr-CA, en;q=0.8, *;q=0.1"}}
+	assert.Equal(t, "en-US", r.NegotiateLanguage("de", "en-US"))
+	assert.Equal(t, "fr-CA", r.NegotiateLanguage("fr", "fr-CA", "en"))
+	assert.Equal(t, "de", r.NegotiateLanguage("de"))
+
+	// Test: Charsets
+	r = &Request{Headers: headers.Headers{"accept-charset": "iso-8859-1;q=0.5, UTF-8"}}
+	assert.Equal(t, "utf-8", r.NegotiateCharset("iso-8859-1", "utf-8"))
+
+	// Test: Negotiated headers are recorded for Vary
+	assert.Equal(t, []string{"accept-charset"}, r.VaryFields())
+	r.NegotiateContentType("text/html")
+	r.NegotiateCharset("utf-8")
+	assert.Equal(t, []string{"accept-charset", "accept"}, r.VaryFields())
+}
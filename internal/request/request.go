@@ -1,8 +1,13 @@
 package request
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode"
@@ -12,6 +17,11 @@ import (
 
 var bufferSize int = 8
 
+// ErrTransferEncoding is returned for requests sent with a Transfer-Encoding.
+// Request bodies are only read by their Content-Length, and reading such a
+// request as bodiless would lose its body without anyone noticing
+var ErrTransferEncoding = errors.New("error: transfer-encoded request bodies aren't supported")
+
 type requestState int
 
 const (
@@ -26,6 +36,48 @@ type Request struct {
 	Headers     headers.Headers
 	Body        []byte
 	ParserState requestState
+	Form        *Form
+	// RemoteAddr is the address of the client the request came from, as
+	// host:port, when the request was read by the server
+	RemoteAddr string
+	ctx        context.Context
+	reader     *Reader
+	varyFields []string
+	bodyErr    error
+}
+
+// Context is cancelled when the request no longer needs answering: by the
+// server when the client goes away, the server shuts down or the request
+// times out. A client going away isn't noticed once the connection has been
+// hijacked, or while a body left for the handler to read is unread.
+// Requests not read by the server get the background context
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of the request carrying ctx, which the
+// copy should be used in place of the original from then on
+func (r *Request) WithContext(ctx context.Context) *Request {
+	copied := *r
+	copied.ctx = ctx
+	return &copied
+}
+
+// Clone returns a copy of the request carrying ctx that shares nothing it
+// could change with the original, for handing to code that runs alongside
+// it. The copy doesn't read from the connection, so it only has as much of
+// the body as had already been read. A parsed Form is shared
+func (r *Request) Clone(ctx context.Context) *Request {
+	copied := *r
+	copied.ctx = ctx
+	copied.Headers = maps.Clone(r.Headers)
+	copied.Body = bytes.Clone(r.Body)
+	copied.varyFields = slices.Clone(r.varyFields)
+	copied.reader = nil
+	return &copied
 }
 
 type RequestLine struct {
@@ -34,59 +86,228 @@ type RequestLine struct {
 	Method        string
 }
 
+// Reader parses requests from a connection, keeping any bytes it has read
+// past the point it has parsed up to in its buffer. Setting AllowObsFold
+// unfolds obsolete line folding in headers instead of rejecting it.
+// MaxDecompressedSize caps the size of gzip or deflate encoded bodies once
+// decoded, with zero meaning the package default
+type Reader struct {
+	AllowObsFold        bool
+	MaxDecompressedSize int64
+	reader              io.Reader
+	buf                 []byte
+	readToIndex         int
+	readerEmpty         bool
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{reader: reader, buf: make([]byte, bufferSize, bufferSize)}
+}
+
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize, bufferSize)
-	readToIndex := 0
-	request := &Request{Headers: make(headers.Headers), ParserState: requestStateInitialized}
-	readerEmpty := false
-	bytesRead := 0
-	var err error
+	request, err := NewReader(reader).ReadHead()
+	if err != nil {
+		return request, err
+	}
+	_, err = request.ReadBody()
+	if err != nil {
+		return request, err
+	}
 
-	for {
-		bytesRead = 0
-		err = nil
+	return request, nil
+}
 
-		if request.ParserState == requestStateDone {
-			break
-		}
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
+// TakeBuffered returns the bytes read from the underlying reader that haven't
+// been parsed yet, removing them from the reader, for a caller taking the
+// connection over after a request
+func (rr *Reader) TakeBuffered() []byte {
+	buffered := make([]byte, rr.readToIndex)
+	copy(buffered, rr.buf[:rr.readToIndex])
+	rr.readToIndex = 0
+	return buffered
+}
+
+// ReadHead parses the request line and headers, leaving the body unread so
+// the caller can decide whether and when to read it
+func (rr *Reader) ReadHead() (*Request, error) {
+	request := &Request{Headers: make(headers.Headers), ParserState: requestStateInitialized, reader: rr}
+	err := rr.readUntil(request, requestStateParsingBody)
+	return request, err
+}
+
+// ReadBody reads the rest of the request body, if it hasn't already been
+// read, and decodes any Content-Encoding it was sent with. A body that failed
+// to read or decode keeps failing with the same error
+func (r *Request) ReadBody() ([]byte, error) {
+	if r.bodyErr != nil {
+		return r.Body, r.bodyErr
+	}
+	if r.reader == nil || r.ParserState == requestStateDone {
+		return r.Body, nil
+	}
+	err := r.reader.readUntil(r, requestStateDone)
+	if err == nil {
+		err = r.decodeBody()
+	}
+	if err != nil {
+		r.bodyErr = err
+		return r.Body, err
+	}
+
+	return r.Body, nil
+}
+
+// BodyReader returns the body as a stream, reading it off the connection as
+// it's consumed if it hasn't been read yet. A streamed body isn't kept in
+// Body. A body sent with a Content-Encoding is read and decoded in full
+// first, as ReadBody does, so the stream is always the decoded body
+func (r *Request) BodyReader() io.Reader {
+	if r.bodyErr != nil {
+		return errReader{r.bodyErr}
+	}
+	if r.reader == nil || r.ParserState == requestStateDone {
+		return bytes.NewReader(r.Body)
+	}
+	if r.Headers.Get("content-encoding") != "" {
+		body, err := r.ReadBody()
+		if err != nil {
+			return errReader{err}
 		}
+		return bytes.NewReader(body)
+	}
+	contentLength, err := strconv.Atoi(r.Headers.Get("content-length"))
+	if err != nil || contentLength <= 0 {
+		r.ParserState = requestStateDone
+		return bytes.NewReader(r.Body)
+	}
+	return &bodyReader{request: r, remaining: contentLength - len(r.Body), prefix: r.Body}
+}
 
-		if !readerEmpty {
-			bytesRead, err = reader.Read(buf[readToIndex:])
-			if err != nil {
-				if err == io.EOF {
-					readerEmpty = true
-				} else {
-					return request, err
-				}
-			}
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read(p []byte) (int, error) {
+	return 0, e.err
+}
+
+type bodyReader struct {
+	request   *Request
+	remaining int
+	prefix    []byte
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if len(b.prefix) > 0 {
+		n := copy(p, b.prefix)
+		b.prefix = b.prefix[n:]
+		return n, nil
+	}
+	if b.remaining <= 0 {
+		b.request.ParserState = requestStateDone
+		return 0, io.EOF
+	}
+	if len(p) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	rr := b.request.reader
+	if rr.readToIndex > 0 {
+		n := copy(p, rr.buf[:rr.readToIndex])
+		copy(rr.buf, rr.buf[n:rr.readToIndex])
+		rr.readToIndex -= n
+		b.remaining -= n
+		return n, nil
+	}
+	if rr.readerEmpty {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n, err := rr.reader.Read(p)
+	b.remaining -= n
+	if err == io.EOF {
+		rr.readerEmpty = true
+		if b.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
 		}
-		readToIndex += bytesRead
+		err = nil
+	}
+	return n, err
+}
 
-		bytesParsed, err := request.parse(buf[:readToIndex])
+// Write serializes the request, with its fields in canonical order and Body
+// after them as it is
+func (r *Request) Write(w io.Writer) error {
+	err := r.WriteHead(w)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(r.Body)
+	return err
+}
+
+// WriteHead serializes the request line and fields, leaving the caller to
+// send a body in whatever framing the fields declare
+func (r *Request) WriteHead(w io.Writer) error {
+	keys, err := r.Headers.SortedKeys()
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(r.RequestLine.Method+r.RequestLine.RequestTarget, " \r\n") {
+		return fmt.Errorf("error: invalid request line")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s: %s\r\n", headers.CanonicalKey(key), r.Headers[key])
+	}
+	b.WriteString("\r\n")
+
+	_, err = w.Write([]byte(b.String()))
+	return err
+}
+
+func (rr *Reader) readUntil(request *Request, state requestState) error {
+	for {
+		bytesParsed, err := request.parse(rr.buf[:rr.readToIndex], state)
 		if err != nil {
-			return request, err
+			return err
+		}
+		copy(rr.buf, rr.buf[bytesParsed:rr.readToIndex])
+		rr.readToIndex -= bytesParsed
+
+		if request.ParserState >= state {
+			return nil
 		}
-		if readerEmpty && bytesParsed == 0 && request.ParserState == requestStateInitialized {
-			return request, fmt.Errorf("error: incomplete data at EOF")
+		if rr.readerEmpty {
+			switch request.ParserState {
+			case requestStateParsingBody:
+				return fmt.Errorf("error: request body is shorter than content-length")
+			default:
+				return fmt.Errorf("error: incomplete data at EOF")
+			}
+		}
+
+		if rr.readToIndex >= len(rr.buf) {
+			newBuf := make([]byte, len(rr.buf)*2)
+			copy(newBuf, rr.buf)
+			rr.buf = newBuf
 		}
-		if readerEmpty && bytesParsed == 0 && request.ParserState == requestStateParsingBody {
-			return request, fmt.Errorf("error: request body is shorter than content-length")
+		bytesRead, err := rr.reader.Read(rr.buf[rr.readToIndex:])
+		rr.readToIndex += bytesRead
+		if err != nil {
+			if err == io.EOF {
+				rr.readerEmpty = true
+			} else {
+				return err
+			}
 		}
-		copy(buf, buf[bytesParsed:readToIndex])
-		readToIndex -= bytesParsed
 	}
-
-	return request, nil
 }
 
-func (r *Request) parse(data []byte) (int, error) {
+func (r *Request) parse(data []byte, until requestState) (int, error) {
 	totalBytesParsed := 0
-	for r.ParserState != requestStateDone {
+	for r.ParserState < until {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return totalBytesParsed, err
@@ -116,11 +337,18 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return numBytes, nil
 		}
 	case requestStateParsingHeaders:
-		bytesParsed, done, err := r.Headers.Parse(data)
+		parseHeader := r.Headers.Parse
+		if r.reader != nil && r.reader.AllowObsFold {
+			parseHeader = r.Headers.ParseLenient
+		}
+		bytesParsed, done, err := parseHeader(data)
 		if err != nil {
 			return 0, err
 		}
 		if done {
+			if r.Headers.Get("transfer-encoding") != "" {
+				return 0, ErrTransferEncoding
+			}
 			r.ParserState = requestStateParsingBody
 			return bytesParsed, nil
 		}
@@ -185,3 +185,74 @@ func TestRequestLineParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.Error(t, err)
 }
+
+func TestRequestReadHead(t *testing.T) {
+	// Test: Head is parsed without consuming the body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"Expect: 100-continue\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := NewReader(reader).ReadHead()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "POST", r.RequestLine.Method)
+	assert.Equal(t, "100-continue", r.Headers["expect"])
+	assert.Equal(t, "", string(r.Body))
+
+	// Test: Body is read on demand, and only once
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+
+	// Test: Body is read from bytes already buffered with the head
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 1024,
+	}
+	r, err = NewReader(reader).ReadHead()
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Connection closed mid headers
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = NewReader(reader).ReadHead()
+	require.Error(t, err)
+}
+
+func TestRequestObsFold(t *testing.T) {
+	data := "GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"X-Folded: one\r\n" +
+		"  two\r\n" +
+		"\r\n"
+
+	// Test: Folded header is rejected by default
+	reader := &chunkReader{data: data, numBytesPerRead: 3}
+	_, err := RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Folded header is unfolded when allowed
+	reader = &chunkReader{data: data, numBytesPerRead: 3}
+	rr := NewReader(reader)
+	rr.AllowObsFold = true
+	r, err := rr.ReadHead()
+	require.NoError(t, err)
+	assert.Equal(t, "one two", r.Headers["x-folded"])
+	assert.Equal(t, "localhost:42069", r.Headers["host"])
+}
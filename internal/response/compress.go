@@ -0,0 +1,262 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+)
+
+// Bodies smaller than this gain little from compression
+const minCompressSize = 1024
+
+type compressFraming int
+
+const (
+	framingNone compressFraming = iota
+	framingContentLength
+	framingChunked
+)
+
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type compressor struct {
+	encoding   string
+	framing    compressFraming
+	encoder    flushWriteCloser
+	compressed []byte
+}
+
+// EnableCompression compresses the response with whichever content coding
+// the request's Accept-Encoding value prefers, if any. The choice is made in
+// WriteHeaders, which adjusts the framing headers to match
+func (w *Writer) EnableCompression(acceptEncoding string) {
+	w.acceptEncoding = acceptEncoding
+	w.compressionEnabled = true
+}
+
+func negotiateEncoding(acceptEncoding string) string {
+	wildcardQ := 0.0
+	explicit := map[string]float64{}
+	for _, spec := range request.ParseAccept(acceptEncoding) {
+		coding := spec.Value
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+		if coding == "*" {
+			wildcardQ = spec.Q
+			continue
+		}
+		if _, ok := explicit[coding]; !ok {
+			explicit[coding] = spec.Q
+		}
+	}
+
+	// gzip wins ties, being the better supported of the two
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := explicit[coding]
+		if !ok {
+			q = wildcardQ
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
+
+var alreadyCompressed = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/x-bzip2",
+}
+
+func compressibleType(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if strings.HasPrefix(contentType, "image/svg+xml") {
+		return true
+	}
+	for _, prefix := range alreadyCompressed {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// prepareCompression decides whether this response gets compressed, returning
+// the header fields adjusted to suit. It's a no-op unless EnableCompression
+// was called
+func (w *Writer) prepareCompression(fields headers.Headers) (headers.Headers, error) {
+	if !w.compressionEnabled || w.compressor != nil {
+		return fields, nil
+	}
+	if w.requestMethod() != "HEAD" && !w.bodyAllowed() {
+		return fields, nil
+	}
+	if _, _, ok := fields.Find("Content-Encoding"); ok {
+		return fields, nil
+	}
+	_, contentType, _ := fields.Find("Content-Type")
+	if !compressibleType(contentType) {
+		return fields, nil
+	}
+
+	framing := framingNone
+	_, transferEncoding, _ := fields.Find("Transfer-Encoding")
+	_, contentLength, hasLength := fields.Find("Content-Length")
+	if strings.Contains(strings.ToLower(transferEncoding), "chunked") {
+		framing = framingChunked
+	} else if hasLength {
+		length, err := strconv.Atoi(contentLength)
+		if err != nil {
+			return fields, nil
+		}
+		if length < minCompressSize {
+			return fields, nil
+		}
+		framing = framingContentLength
+	}
+
+	fields = maps.Clone(fields)
+	fields["Vary"] = addVary(fields, "Accept-Encoding")
+	encoding := negotiateEncoding(w.acceptEncoding)
+	if encoding == "" {
+		return fields, nil
+	}
+
+	c := &compressor{encoding: encoding, framing: framing}
+	fields.Del("Content-Encoding")
+	fields["Content-Encoding"] = encoding
+	// A strong tag vouches for the exact bytes, which the encoded body no
+	// longer matches
+	if key, etag, ok := fields.Find("ETag"); ok && !strings.HasPrefix(etag, "W/") {
+		fields[key] = "W/" + etag
+	}
+	switch framing {
+	case framingContentLength:
+		length, _ := strconv.Atoi(contentLength)
+		fields.Del("Content-Length")
+		if len(w.Body) == length {
+			// The whole body is already known, so it can be compressed up
+			// front and keep its Content-Length framing
+			compressed, err := compressBytes(encoding, w.Body)
+			if err != nil {
+				return nil, err
+			}
+			c.compressed = compressed
+			fields["Content-Length"] = strconv.Itoa(len(compressed))
+		} else {
+			// Otherwise the compressed length isn't known until it's written
+			c.framing = framingChunked
+			fields.Del("Transfer-Encoding")
+			fields["Transfer-Encoding"] = "chunked"
+			c.encoder = newEncoder(encoding, chunkSink{w})
+		}
+	case framingChunked:
+		c.encoder = newEncoder(encoding, chunkSink{w})
+	default:
+		c.encoder = newEncoder(encoding, w.ResponseWriter)
+	}
+	w.compressor = c
+
+	return fields, nil
+}
+
+func addVary(fields headers.Headers, name string) string {
+	key, vary, ok := fields.Find("Vary")
+	if !ok || strings.TrimSpace(vary) == "" {
+		return name
+	}
+	delete(fields, key)
+	for _, existing := range strings.Split(vary, ",") {
+		existing = strings.TrimSpace(existing)
+		if existing == "*" || strings.EqualFold(existing, name) {
+			return vary
+		}
+	}
+	return vary + ", " + name
+}
+
+func newEncoder(encoding string, dst io.Writer) flushWriteCloser {
+	if encoding == "deflate" {
+		// HTTP's deflate coding is the zlib format wrapped around raw deflate
+		return zlib.NewWriter(dst)
+	}
+	return gzip.NewWriter(dst)
+}
+
+func compressBytes(encoding string, p []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := newEncoder(encoding, buf)
+	_, err := encoder.Write(p)
+	if err != nil {
+		return nil, fmt.Errorf("error compressing body: %s", err)
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error compressing body: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// chunkSink frames everything the encoder emits as chunks
+type chunkSink struct {
+	w *Writer
+}
+
+func (c chunkSink) Write(p []byte) (int, error) {
+	_, err := c.w.writeChunk(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// write compresses p, flushing so each call reaches the client as it would
+// uncompressed, which streaming responses rely on
+func (c *compressor) write(p []byte) error {
+	if c.encoder == nil {
+		return fmt.Errorf("error: body was already compressed")
+	}
+	_, err := c.encoder.Write(p)
+	if err != nil {
+		return fmt.Errorf("error compressing body: %s", err)
+	}
+	err = c.encoder.Flush()
+	if err != nil {
+		return fmt.Errorf("error compressing body: %s", err)
+	}
+	return nil
+}
+
+func (c *compressor) close() error {
+	if c.encoder == nil {
+		return nil
+	}
+	err := c.encoder.Close()
+	if err != nil {
+		return fmt.Errorf("error compressing body: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	assert.Equal(t, "gzip", negotiateEncoding("gzip, deflate, br"))
+	assert.Equal(t, "deflate", negotiateEncoding("gzip;q=0.5, deflate"))
+	assert.Equal(t, "gzip", negotiateEncoding("deflate;q=0.5, *"))
+	assert.Equal(t, "", negotiateEncoding("gzip;q=0, deflate;q=0"))
+	assert.Equal(t, "", negotiateEncoding("*;q=0"))
+	assert.Equal(t, "", negotiateEncoding("br, identity"))
+	assert.Equal(t, "", negotiateEncoding(""))
+	assert.Equal(t, "gzip", negotiateEncoding("x-gzip"))
+}
+
+func TestCompression(t *testing.T) {
+	page := strings.Repeat("<p>Your request was an absolute banger.</p>\n", 50)
+
+	// Test: Content-Length body is compressed up front
+	buf := &bytes.Buffer{}
+	w := Writer{ResponseWriter: buf}
+	w.EnableCompression("gzip")
+	w.Body = []byte(page)
+	w.WriteStatusLine()
+	err := w.WriteHeaders(headers.Headers{"Content-Length": strconv.Itoa(len(page)), "Content-Type": "text/html", "ETag": `"v1"`})
+	require.NoError(t, err)
+	_, err = w.WriteBody()
+	require.NoError(t, err)
+	head, body, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	assert.Contains(t, head, "Content-Encoding: gzip\r\n")
+	assert.Contains(t, head, "Etag: W/\"v1\"\r\n")
+	assert.Contains(t, head, "Vary: Accept-Encoding")
+	assert.Contains(t, head, "Content-Length: "+strconv.Itoa(len(body))+"\r\n")
+	gz, err := gzip.NewReader(strings.NewReader(body))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+
+	// Test: Chunked body is compressed as it streams
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf}
+	w.EnableCompression("deflate")
+	w.CaptureBody(len(page))
+	w.WriteStatusLine()
+	err = w.WriteHeaders(headers.Headers{"Transfer-Encoding": "chunked", "Content-Type": "text/plain"})
+	require.NoError(t, err)
+	w.WriteChunkedBody([]byte(page[:100]))
+	w.WriteChunkedBody([]byte(page[100:]))
+	w.WriteChunkedBodyDone()
+	w.WriteTrailers(nil)
+	resp, err := ResponseFromReader(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "deflate", resp.Headers.Get("content-encoding"))
+	zr, err := zlib.NewReader(bytes.NewReader(resp.Body))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+	captured, ok := w.CapturedBody()
+	assert.True(t, ok)
+	assert.Equal(t, page, string(captured))
+
+	// Test: Small body is left alone
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf}
+	w.EnableCompression("gzip")
+	w.Body = []byte("tiny")
+	w.WriteHeaders(headers.Headers{"Content-Length": "4", "Content-Type": "text/plain"})
+	w.WriteBody()
+	assert.Equal(t, "Content-Length: 4\r\nContent-Type: text/plain\r\n\r\ntiny", buf.String())
+
+	// Test: Already compressed types are left alone
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf}
+	w.EnableCompression("gzip")
+	w.Body = []byte(page)
+	w.WriteHeaders(headers.Headers{"Content-Length": strconv.Itoa(len(page)), "Content-Type": "image/png"})
+	assert.NotContains(t, buf.String(), "Content-Encoding")
+
+	// Test: Identity is negotiated but Vary is still set
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf}
+	w.EnableCompression("identity")
+	w.Body = []byte(page)
+	w.WriteHeaders(headers.Headers{"Content-Length": strconv.Itoa(len(page)), "Content-Type": "text/html", "Vary": "Cookie"})
+	w.WriteBody()
+	assert.NotContains(t, buf.String(), "Content-Encoding")
+	assert.Contains(t, buf.String(), "Vary: Cookie, Accept-Encoding\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), page))
+
+	// Test: Content-Length body not yet known switches to chunked
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf}
+	w.EnableCompression("gzip")
+	w.WriteStatusLine()
+	w.WriteHeaders(headers.Headers{"Content-Length": strconv.Itoa(len(page)), "Content-Type": "text/html"})
+	w.Body = []byte(page)
+	w.WriteBody()
+	resp, err = ResponseFromReader(buf)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Headers.Get("content-length"))
+	assert.Equal(t, "chunked", resp.Headers.Get("transfer-encoding"))
+	gz, err = gzip.NewReader(bytes.NewReader(resp.Body))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+}
@@ -0,0 +1,124 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+)
+
+// ETag returns a quoted entity tag derived from the body's content
+func ETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// FileETag returns a weak entity tag from a file's size and modification
+// time, avoiding a read of its content
+func FileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// CheckPreconditions evaluates the request's conditional headers against the
+// representation's ETag and modification time, in the order RFC 9110 section
+// 13.2.2 lays out. If a condition fails it writes the 304 or 412 response and
+// returns true, in which case the handler has nothing more to do. Either
+// validator may be left empty
+func (w *Writer) CheckPreconditions(etag string, lastModified time.Time) bool {
+	if w.Request == nil {
+		return false
+	}
+	h := w.Request.Headers
+	lastModified = lastModified.Truncate(time.Second)
+	method := w.Request.RequestLine.Method
+	safe := method == "GET" || method == "HEAD"
+
+	if ifMatch := h.Get("if-match"); ifMatch != "" {
+		if !matchETag(ifMatch, etag, false) {
+			return w.writePreconditionFailed()
+		}
+	} else if since, ok := parseHTTPDate(h.Get("if-unmodified-since")); ok && !lastModified.IsZero() {
+		if lastModified.After(since) {
+			return w.writePreconditionFailed()
+		}
+	}
+
+	if ifNoneMatch := h.Get("if-none-match"); ifNoneMatch != "" {
+		if matchETag(ifNoneMatch, etag, true) {
+			if safe {
+				return w.writeNotModified(etag, lastModified)
+			}
+			return w.writePreconditionFailed()
+		}
+	} else if since, ok := parseHTTPDate(h.Get("if-modified-since")); ok && safe && !lastModified.IsZero() {
+		if !lastModified.After(since) {
+			return w.writeNotModified(etag, lastModified)
+		}
+	}
+
+	return false
+}
+
+// matchETag reports whether etag is in a comma separated list of entity tags,
+// using weak comparison for If-None-Match and strong for If-Match
+func matchETag(list, etag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return etag != ""
+	}
+	if etag == "" {
+		return false
+	}
+	if !weak && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if !weak && strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := headers.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func (w *Writer) writeNotModified(etag string, lastModified time.Time) bool {
+	h := headers.NewHeaders()
+	if etag != "" {
+		h["ETag"] = etag
+	}
+	if !lastModified.IsZero() {
+		h["Last-Modified"] = lastModified.UTC().Format(headers.TimeFormat)
+	}
+	w.Status = Code304
+	w.WriteStatusLine()
+	w.WriteHeaders(h)
+	return true
+}
+
+func (w *Writer) writePreconditionFailed() bool {
+	w.Status = Code412
+	w.WriteStatusLine()
+	w.WriteHeaders(headers.Headers{"Content-Length": "0"})
+	return true
+}
@@ -0,0 +1,95 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func conditionalWriter(method string, h headers.Headers) (*Writer, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	req := &request.Request{RequestLine: request.RequestLine{Method: method}, Headers: h}
+	return &Writer{ResponseWriter: buf, Request: req}, buf
+}
+
+func TestETag(t *testing.T) {
+	strong := ETag([]byte("hello"), false)
+	weak := ETag([]byte("hello"), true)
+	assert.Equal(t, `"`, strong[:1])
+	assert.Equal(t, "W/"+strong, weak)
+	assert.Equal(t, strong, ETag([]byte("hello"), false))
+	assert.NotEqual(t, strong, ETag([]byte("world"), false))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	etag := ETag([]byte("hello"), false)
+	modified := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	before := modified.Add(-time.Hour).Format(headers.TimeFormat)
+	after := modified.Add(time.Hour).Format(headers.TimeFormat)
+
+	// Test: No conditional headers
+	w, buf := conditionalWriter("GET", headers.NewHeaders())
+	assert.False(t, w.CheckPreconditions(etag, modified))
+	assert.Equal(t, "", buf.String())
+
+	// Test: If-None-Match hit on GET
+	w, buf = conditionalWriter("GET", headers.Headers{"if-none-match": `"other", ` + etag})
+	assert.True(t, w.CheckPreconditions(etag, modified))
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\nEtag: "+etag+"\r\nLast-Modified: Sat, 01 Mar 2025 12:00:00 GMT\r\n\r\n", buf.String())
+
+	// Test: If-None-Match uses weak comparison
+	w, _ = conditionalWriter("GET", headers.Headers{"if-none-match": "W/" + etag})
+	assert.True(t, w.CheckPreconditions(etag, modified))
+
+	// Test: If-None-Match hit on POST
+	w, buf = conditionalWriter("POST", headers.Headers{"if-none-match": "*"})
+	assert.True(t, w.CheckPreconditions(etag, modified))
+	assert.Equal(t, "HTTP/1.1 412 Precondition Failed\r\nContent-Length: 0\r\n\r\n", buf.String())
+
+	// Test: If-None-Match miss takes precedence over If-Modified-Since
+	w, _ = conditionalWriter("GET", headers.Headers{"if-none-match": `"other"`, "if-modified-since": after})
+	assert.False(t, w.CheckPreconditions(etag, modified))
+
+	// Test: If-Modified-Since
+	w, _ = conditionalWriter("GET", headers.Headers{"if-modified-since": after})
+	assert.True(t, w.CheckPreconditions(etag, modified))
+	w, _ = conditionalWriter("GET", headers.Headers{"if-modified-since": before})
+	assert.False(t, w.CheckPreconditions(etag, modified))
+
+	// Test: If-Modified-Since in the obsolete RFC 850 and asctime formats
+	w, _ = conditionalWriter("GET", headers.Headers{"if-modified-since": "Saturday, 01-Mar-25 13:00:00 GMT"})
+	assert.True(t, w.CheckPreconditions(etag, modified))
+	w, _ = conditionalWriter("GET", headers.Headers{"if-modified-since": "Sat Mar  1 13:00:00 2025"})
+	assert.True(t, w.CheckPreconditions(etag, modified))
+	w, _ = conditionalWriter("GET", headers.Headers{"if-modified-since": "Sat Mar  1 11:00:00 2025"})
+	assert.False(t, w.CheckPreconditions(etag, modified))
+
+	// Test: If-Modified-Since ignored for POST
+	w, _ = conditionalWriter("POST", headers.Headers{"if-modified-since": after})
+	assert.False(t, w.CheckPreconditions(etag, modified))
+
+	// Test: If-Match uses strong comparison
+	w, _ = conditionalWriter("PUT", headers.Headers{"if-match": etag})
+	assert.False(t, w.CheckPreconditions(etag, modified))
+	w, buf = conditionalWriter("PUT", headers.Headers{"if-match": "W/" + etag})
+	assert.True(t, w.CheckPreconditions(etag, modified))
+	assert.Contains(t, buf.String(), "412 Precondition Failed")
+
+	// Test: If-Match takes precedence over If-Unmodified-Since
+	w, _ = conditionalWriter("PUT", headers.Headers{"if-match": etag, "if-unmodified-since": before})
+	assert.False(t, w.CheckPreconditions(etag, modified))
+
+	// Test: If-Unmodified-Since
+	w, _ = conditionalWriter("PUT", headers.Headers{"if-unmodified-since": before})
+	assert.True(t, w.CheckPreconditions(etag, modified))
+	w, _ = conditionalWriter("PUT", headers.Headers{"if-unmodified-since": after})
+	assert.False(t, w.CheckPreconditions(etag, modified))
+
+	// Test: Malformed dates are ignored
+	w, _ = conditionalWriter("GET", headers.Headers{"if-modified-since": "yesterday"})
+	assert.False(t, w.CheckPreconditions(etag, modified))
+}
@@ -0,0 +1,364 @@
+package response
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+)
+
+var bufferSize int = 1024
+
+type responseState int
+
+const (
+	responseStateStatusLine responseState = iota
+	responseStateHeaders
+	responseStateBody
+	responseStateChunkSize
+	responseStateChunkData
+	responseStateChunkEnd
+	responseStateTrailers
+	responseStateUntilClose
+	responseStateDone
+)
+
+// Response is a response parsed off a connection, the counterpart of
+// request.Request. Until the body has been read in full, Body holds only the
+// body bytes parsed so far that haven't been consumed through BodyReader,
+// and Trailers is filled in once a chunked body has been read to its end.
+// Set-Cookie fields are kept apart from Headers in SetCookies, one value per
+// field line, since comma-joining them can't be undone reliably.
+// OnChunk, if set, is called with the size of each chunk of a chunked body
+// as its size line is parsed, the last one being zero
+type Response struct {
+	HttpVersion string
+	StatusCode  StatusCode
+	Reason      string
+	Headers     headers.Headers
+	SetCookies  []string
+	Trailers    headers.Headers
+	Body        []byte
+	OnChunk     func(size int64)
+	ParserState responseState
+	reader      *Reader
+	method      string
+	remaining   int64
+}
+
+// Reader parses responses from a connection, keeping any bytes it has read
+// past the point it has parsed up to in its buffer
+type Reader struct {
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+	readerEmpty bool
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{reader: reader, buf: make([]byte, bufferSize)}
+}
+
+// TakeBuffered returns the bytes read from the underlying reader that haven't
+// been parsed yet, removing them from the reader, for a caller taking the
+// connection over after a response such as 101 Switching Protocols
+func (rr *Reader) TakeBuffered() []byte {
+	buffered := make([]byte, rr.readToIndex)
+	copy(buffered, rr.buf[:rr.readToIndex])
+	rr.readToIndex = 0
+	return buffered
+}
+
+// ResponseFromReader parses a whole response, body included, as the answer
+// to a request that wasn't HEAD
+func ResponseFromReader(reader io.Reader) (*Response, error) {
+	response, err := NewReader(reader).ReadHead("GET")
+	if err != nil {
+		return response, err
+	}
+	_, err = response.ReadBody()
+	if err != nil {
+		return response, err
+	}
+
+	return response, nil
+}
+
+// ReadHead parses the status line and headers of the next final response,
+// skipping over any interim 1xx responses other than 101. method is that of
+// the request being answered, since a response to HEAD has no body whatever
+// its headers say
+func (rr *Reader) ReadHead(method string) (*Response, error) {
+	for {
+		response := &Response{Headers: headers.NewHeaders(), Trailers: headers.NewHeaders(), reader: rr, method: method}
+		err := rr.readUntil(response, func() bool { return response.ParserState > responseStateHeaders })
+		if err != nil {
+			return response, err
+		}
+		if response.StatusCode >= 100 && response.StatusCode <= 199 && response.StatusCode != 101 {
+			continue
+		}
+		return response, nil
+	}
+}
+
+// ReadBody reads the rest of the body, returning all of it that hasn't
+// already been consumed through BodyReader
+func (r *Response) ReadBody() ([]byte, error) {
+	if r.reader == nil || r.ParserState == responseStateDone {
+		return r.Body, nil
+	}
+	err := r.reader.readUntil(r, func() bool { return r.ParserState == responseStateDone })
+	return r.Body, err
+}
+
+// Complete reports whether the response has been parsed to its end
+func (r *Response) Complete() bool {
+	return r.ParserState == responseStateDone
+}
+
+// BodyReader returns the body as a stream, parsing it off the connection as
+// it's consumed
+func (r *Response) BodyReader() io.Reader {
+	return &bodyReader{response: r}
+}
+
+type bodyReader struct {
+	response *Response
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	r := b.response
+	if len(r.Body) == 0 && r.ParserState != responseStateDone && r.reader != nil {
+		err := r.reader.readUntil(r, func() bool { return len(r.Body) > 0 || r.ParserState == responseStateDone })
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(r.Body) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.Body)
+	r.Body = r.Body[n:]
+	return n, nil
+}
+
+func (rr *Reader) readUntil(response *Response, done func() bool) error {
+	for {
+		bytesParsed, err := response.parse(rr.buf[:rr.readToIndex], done)
+		if err != nil {
+			return err
+		}
+		copy(rr.buf, rr.buf[bytesParsed:rr.readToIndex])
+		rr.readToIndex -= bytesParsed
+
+		if done() {
+			return nil
+		}
+		if rr.readerEmpty {
+			switch response.ParserState {
+			case responseStateUntilClose:
+				response.ParserState = responseStateDone
+				continue
+			case responseStateStatusLine:
+				if rr.readToIndex == 0 {
+					return io.EOF
+				}
+				return io.ErrUnexpectedEOF
+			default:
+				return io.ErrUnexpectedEOF
+			}
+		}
+
+		if rr.readToIndex >= len(rr.buf) {
+			newBuf := make([]byte, len(rr.buf)*2)
+			copy(newBuf, rr.buf)
+			rr.buf = newBuf
+		}
+		bytesRead, err := rr.reader.Read(rr.buf[rr.readToIndex:])
+		rr.readToIndex += bytesRead
+		if err != nil {
+			if err == io.EOF {
+				rr.readerEmpty = true
+			} else {
+				return err
+			}
+		}
+	}
+}
+
+func (r *Response) parse(data []byte, done func() bool) (int, error) {
+	totalBytesParsed := 0
+	for !done() {
+		n, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return totalBytesParsed, err
+		}
+		if n == 0 {
+			break
+		}
+		totalBytesParsed += n
+	}
+
+	return totalBytesParsed, nil
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.ParserState {
+	case responseStateStatusLine:
+		lineEnd := strings.Index(string(data), "\r\n")
+		if lineEnd == -1 {
+			return 0, nil
+		}
+		err := r.parseStatusLine(string(data[:lineEnd]))
+		if err != nil {
+			return 0, err
+		}
+		r.ParserState = responseStateHeaders
+		return lineEnd + 2, nil
+	case responseStateHeaders:
+		fields := r.Headers
+		if isSetCookie(data) {
+			fields = headers.NewHeaders()
+		}
+		bytesParsed, done, err := fields.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if value, ok := fields["set-cookie"]; ok && bytesParsed > 0 {
+			r.SetCookies = append(r.SetCookies, value)
+		}
+		if done {
+			err = r.startBody()
+			if err != nil {
+				return 0, err
+			}
+		}
+		return bytesParsed, nil
+	case responseStateBody, responseStateChunkData:
+		if len(data) == 0 {
+			return 0, nil
+		}
+		n := len(data)
+		if int64(n) > r.remaining {
+			n = int(r.remaining)
+		}
+		r.Body = append(r.Body, data[:n]...)
+		r.remaining -= int64(n)
+		if r.remaining == 0 {
+			if r.ParserState == responseStateBody {
+				r.ParserState = responseStateDone
+			} else {
+				r.ParserState = responseStateChunkEnd
+			}
+		}
+		return n, nil
+	case responseStateChunkSize:
+		lineEnd := strings.Index(string(data), "\r\n")
+		if lineEnd == -1 {
+			return 0, nil
+		}
+		sizeText, _, _ := strings.Cut(string(data[:lineEnd]), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("error: invalid chunk size %q", sizeText)
+		}
+		if r.OnChunk != nil {
+			r.OnChunk(size)
+		}
+		if size == 0 {
+			r.ParserState = responseStateTrailers
+		} else {
+			r.remaining = size
+			r.ParserState = responseStateChunkData
+		}
+		return lineEnd + 2, nil
+	case responseStateChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if string(data[:2]) != "\r\n" {
+			return 0, fmt.Errorf("error: chunk data longer than its size")
+		}
+		r.ParserState = responseStateChunkSize
+		return 2, nil
+	case responseStateTrailers:
+		bytesParsed, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.ParserState = responseStateDone
+		}
+		return bytesParsed, nil
+	case responseStateUntilClose:
+		r.Body = append(r.Body, data...)
+		return len(data), nil
+	case responseStateDone:
+		return 0, fmt.Errorf("error: trying to read data in a done state")
+	default:
+		return 0, fmt.Errorf("error: unknown state")
+	}
+}
+
+func (r *Response) parseStatusLine(line string) error {
+	version, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return fmt.Errorf("error: malformed status line %q", line)
+	}
+	if version != "HTTP/1.1" && version != "HTTP/1.0" {
+		return fmt.Errorf("error: bad http version %q", version)
+	}
+	codeText, reason, _ := strings.Cut(rest, " ")
+	code, err := strconv.Atoi(codeText)
+	if err != nil || len(codeText) != 3 {
+		return fmt.Errorf("error: malformed status code %q", codeText)
+	}
+
+	r.HttpVersion = strings.TrimPrefix(version, "HTTP/")
+	r.StatusCode = StatusCode(code)
+	r.Reason = reason
+	return nil
+}
+
+// startBody works out how the body is framed once the headers are in,
+// RFC 9112 section 6.3
+func (r *Response) startBody() error {
+	if r.method == "HEAD" || (r.StatusCode >= 100 && r.StatusCode <= 199) || r.StatusCode == 204 || r.StatusCode == 304 {
+		r.ParserState = responseStateDone
+		return nil
+	}
+	if te := r.Headers.Get("transfer-encoding"); te != "" {
+		codings := strings.Split(strings.ToLower(te), ",")
+		if strings.TrimSpace(codings[len(codings)-1]) == "chunked" {
+			r.ParserState = responseStateChunkSize
+		} else {
+			r.ParserState = responseStateUntilClose
+		}
+		return nil
+	}
+	if contentLength := r.Headers.Get("content-length"); contentLength != "" {
+		n, err := strconv.ParseInt(contentLength, 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("error: invalid content-length %q", contentLength)
+		}
+		if n == 0 {
+			r.ParserState = responseStateDone
+			return nil
+		}
+		r.remaining = n
+		r.ParserState = responseStateBody
+		return nil
+	}
+	r.ParserState = responseStateUntilClose
+	return nil
+}
+
+// isSetCookie reports whether the field line at the start of data is a
+// Set-Cookie field
+func isSetCookie(data []byte) bool {
+	const prefix = "set-cookie:"
+	return len(data) >= len(prefix) && strings.EqualFold(string(data[:len(prefix)]), prefix)
+}
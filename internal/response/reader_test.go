@@ -0,0 +1,101 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readResponse(data, method string, perRead int) (*Response, []byte, error) {
+	resp, err := NewReader(&testutil.ChunkReader{Data: data, NumBytesPerRead: perRead}).ReadHead(method)
+	if err != nil {
+		return resp, nil, err
+	}
+	body, err := io.ReadAll(resp.BodyReader())
+	return resp, body, err
+}
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Content-Length body
+	resp, body, err := readResponse("HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhello", "GET", 3)
+	require.NoError(t, err)
+	assert.Equal(t, Code200, resp.StatusCode)
+	assert.Equal(t, "OK", resp.Reason)
+	assert.Equal(t, "text/plain", resp.Headers.Get("content-type"))
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Chunked body with trailers
+	resp, body, err = readResponse("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Sum: abc\r\n\r\n", "GET", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "abc", resp.Trailers.Get("x-sum"))
+
+	// Test: Set-Cookie fields are kept one value each, out of Headers
+	resp, _, err = readResponse("HTTP/1.1 200 OK\r\nSet-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nset-cookie: b=2\r\nContent-Length: 0\r\n\r\n", "GET", 6)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, resp.SetCookies)
+	assert.Empty(t, resp.Headers.Get("set-cookie"))
+
+	// Test: Body without framing runs until the connection closes
+	_, body, err = readResponse("HTTP/1.0 200 OK\r\n\r\nuntil the end", "GET", 4)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(body))
+
+	// Test: HEAD and 304 responses have no body whatever their headers say
+	_, body, err = readResponse("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n", "HEAD", 5)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+	_, body, err = readResponse("HTTP/1.1 304 Not Modified\r\nContent-Length: 10\r\n\r\n", "GET", 5)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	// Test: Interim responses are skipped
+	resp, body, err = readResponse("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\nHTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok", "POST", 7)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(201), resp.StatusCode)
+	assert.Empty(t, resp.Headers.Get("link"))
+	assert.Equal(t, "ok", string(body))
+
+	// Test: ResponseFromReader reads the whole body
+	resp, err = ResponseFromReader(&testutil.ChunkReader{Data: "HTTP/1.1 200 OK\r\nContent-Length: 11\r\n\r\nhello world", NumBytesPerRead: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(resp.Body))
+
+	// Test: Body shorter than Content-Length
+	_, _, err = readResponse("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort", "GET", 3)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Chunk data overrunning its size
+	_, _, err = readResponse("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nabc\r\n0\r\n\r\n", "GET", 3)
+	assert.Error(t, err)
+
+	// Test: Malformed status lines
+	_, _, err = readResponse("HTTP/2 200 OK\r\n\r\n", "GET", 3)
+	assert.Error(t, err)
+	_, _, err = readResponse("HTTP/1.1 20 OK\r\n\r\n", "GET", 3)
+	assert.Error(t, err)
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	// Test: Chunked response with trailers parses back to what was written
+	buf := &bytes.Buffer{}
+	w := Writer{ResponseWriter: buf, Status: Code404}
+	w.WriteStatusLine()
+	w.WriteHeaders(headers.Headers{"Transfer-Encoding": "chunked", "Trailer": "X-Count"})
+	w.WriteChunkedBody([]byte("not "))
+	w.WriteChunkedBody([]byte("found"))
+	w.WriteChunkedBodyDone()
+	w.WriteTrailers(headers.Headers{"X-Count": "2"})
+	resp, err := ResponseFromReader(buf)
+	require.NoError(t, err)
+	assert.Equal(t, Code404, resp.StatusCode)
+	assert.Equal(t, "Not Found", resp.Reason)
+	assert.Equal(t, "X-Count", resp.Headers.Get("trailer"))
+	assert.Equal(t, "not found", string(resp.Body))
+	assert.Equal(t, "2", resp.Trailers.Get("x-count"))
+}
@@ -1,86 +1,435 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net"
+	"strings"
 
+	"github.com/jms-guy/httpfromtcp/internal/cookie"
 	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
 )
 
 type StatusCode int
 
 const (
-	Code200 StatusCode = iota
-	Code400
-	Code500
+	Code100 StatusCode = 100
+	Code101 StatusCode = 101
+	Code103 StatusCode = 103
+	Code200 StatusCode = 200
+	Code204 StatusCode = 204
+	Code304 StatusCode = 304
+	Code400 StatusCode = 400
+	Code404 StatusCode = 404
+	Code405 StatusCode = 405
+	Code411 StatusCode = 411
+	Code412 StatusCode = 412
+	Code413 StatusCode = 413
+	Code415 StatusCode = 415
+	Code417 StatusCode = 417
+	Code426 StatusCode = 426
+	Code500 StatusCode = 500
+	Code502 StatusCode = 502
+	Code503 StatusCode = 503
+	Code504 StatusCode = 504
 )
 
-type Writer struct {
-	ResponseWriter io.Writer
-	Status         StatusCode
-	Headers        headers.Headers
-	Body           []byte
-}
-
-func (w *Writer) WriteStatusLine() error {
-	statusLine := ""
-	switch w.Status {
+func (c StatusCode) reasonPhrase() string {
+	switch c {
+	case Code100:
+		return "Continue"
+	case Code101:
+		return "Switching Protocols"
+	case Code103:
+		return "Early Hints"
 	case Code200:
-		statusLine = "HTTP/1.1 200 OK\r\n"
+		return "OK"
+	case Code204:
+		return "No Content"
+	case Code304:
+		return "Not Modified"
 	case Code400:
-		statusLine = "HTTP/1.1 400 Bad Request\r\n"
+		return "Bad Request"
+	case Code404:
+		return "Not Found"
+	case Code405:
+		return "Method Not Allowed"
+	case Code411:
+		return "Length Required"
+	case Code412:
+		return "Precondition Failed"
+	case Code413:
+		return "Content Too Large"
+	case Code415:
+		return "Unsupported Media Type"
+	case Code417:
+		return "Expectation Failed"
+	case Code426:
+		return "Upgrade Required"
 	case Code500:
-		statusLine = "HTTP/1.1 500 Internal Server Error\r\n"
+		return "Internal Server Error"
+	case Code502:
+		return "Bad Gateway"
+	case Code503:
+		return "Service Unavailable"
+	case Code504:
+		return "Gateway Timeout"
 	default:
+		return ""
 	}
+}
+
+// Header names are written in canonical form (Content-Type) unless
+// PreserveHeaderCase is set, in which case they're written as given.
+// Reason, when set, is sent in place of the standard reason phrase for
+// Status. Request is the request being answered, if known. Body bytes are silently
+// dropped when it's a HEAD request or the status is one that can't carry a
+// body, while headers are still written as given, and any headers it has
+// been negotiated on are added to Vary. Hijacker, set by the server, hands over
+// the connection for Hijack
+type Writer struct {
+	ResponseWriter     io.Writer
+	Hijacker           func() (net.Conn, []byte, error)
+	Status             StatusCode
+	Reason             string
+	Headers            headers.Headers
+	Body               []byte
+	Request            *request.Request
+	PreserveHeaderCase bool
+	cookies            []string
+	wroteStatus        bool
+	chunksDone         bool
+	complete           bool
+	streamed           bool
+	captureLimit       int
+	captured           []byte
+	captureOverflowed  bool
+	acceptEncoding     string
+	compressionEnabled bool
+	compressor         *compressor
+}
+
+var ErrHijacked = errors.New("error: connection has been hijacked")
+
+// Hijack takes the connection over from the server, for handlers that switch
+// to another protocol. The bytes returned were read from the connection past
+// the request but not parsed, and come before anything still to be read from
+// it. The server neither writes to nor closes a hijacked connection, and
+// writing through w fails with ErrHijacked afterwards
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.Hijacker == nil {
+		return nil, nil, fmt.Errorf("error: connection can't be hijacked")
+	}
+	if w.ResponseWriter == (hijackedWriter{}) {
+		return nil, nil, ErrHijacked
+	}
+	conn, buffered, err := w.Hijacker()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.ResponseWriter = hijackedWriter{}
+	return conn, buffered, nil
+}
+
+type hijackedWriter struct{}
+
+func (hijackedWriter) Write(p []byte) (int, error) {
+	return 0, ErrHijacked
+}
+
+func (w *Writer) WriteStatusLine() error {
+	// The zero value has always meant 200 OK
+	if w.Status == 0 {
+		w.Status = Code200
+	}
+	reason := w.Reason
+	if reason == "" {
+		reason = w.Status.reasonPhrase()
+	} else if !headers.ValidFieldValue(reason) {
+		return fmt.Errorf("error: invalid reason phrase %q", reason)
+	}
+	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", w.Status, reason)
 
 	_, err := w.ResponseWriter.Write([]byte(statusLine))
 	if err != nil {
 		return err
 	}
+	w.wroteStatus = true
 
 	return nil
 }
 
+// WriteInformational sends an interim 1xx response, such as 100 Continue or
+// 103 Early Hints. It can be called any number of times before WriteStatusLine
+func (w *Writer) WriteInformational(code StatusCode, headers headers.Headers) error {
+	if code < 100 || code > 199 {
+		return fmt.Errorf("error: %d is not an informational status code", code)
+	}
+	if w.wroteStatus {
+		return fmt.Errorf("error: informational response after final status line")
+	}
+
+	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", code, code.reasonPhrase())
+	_, err := w.ResponseWriter.Write([]byte(statusLine))
+	if err != nil {
+		return err
+	}
+	err = w.writeFields(headers, nil)
+	if err != nil {
+		return err
+	}
+	_, err = w.ResponseWriter.Write([]byte("\r\n"))
+	if err != nil {
+		return fmt.Errorf("error writing final CLRF: %s", err)
+	}
+
+	return nil
+}
+
+// WriteHeaders writes the header block and records it in w.Headers, as given
+// by the handler before any compression adjustments
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	for key, val := range headers {
-		_, err := w.ResponseWriter.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, val)))
-		if err != nil {
-			return fmt.Errorf("error writing header: %s %s: %s", key, val, err)
-		}
+	headers = w.addNegotiatedVary(headers)
+	w.Headers = headers
+	headers, err := w.prepareCompression(headers)
+	if err != nil {
+		return err
+	}
+	err = w.writeFields(headers, w.cookies)
+	if err != nil {
+		return err
 	}
-	_, err := w.ResponseWriter.Write([]byte("\r\n"))
+	_, err = w.ResponseWriter.Write([]byte("\r\n"))
 	if err != nil {
 		return fmt.Errorf("error writing final CLRF: %s", err)
 	}
+	// With no body to follow, the header block ends the response
+	if _, length, _ := headers.Find("Content-Length"); !w.bodyAllowed() || length == "0" {
+		w.complete = true
+	}
+
+	return nil
+}
+
+// Complete reports whether the whole response has been written: the header
+// block of a response without a body, all of w.Body through WriteBody, or the
+// last chunk and the trailers of a chunked body
+func (w *Writer) Complete() bool {
+	return w.complete
+}
+
+func (w *Writer) addNegotiatedVary(fields headers.Headers) headers.Headers {
+	if w.Request == nil || len(w.Request.VaryFields()) == 0 {
+		return fields
+	}
+	fields = maps.Clone(fields)
+	if fields == nil {
+		fields = headers.NewHeaders()
+	}
+	for _, field := range w.Request.VaryFields() {
+		fields["Vary"] = addVary(fields, headers.CanonicalKey(field))
+	}
+	return fields
+}
+
+// SetCookie queues a Set-Cookie field for the next WriteHeaders call. Each cookie
+// is written on its own line since Set-Cookie values can't be comma-joined
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	value, err := c.Encode()
+	if err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, value)
+	return nil
+}
 
+// AddSetCookie queues a Set-Cookie field value as it is, for passing on one
+// received from elsewhere without re-encoding it
+func (w *Writer) AddSetCookie(value string) error {
+	if !headers.ValidFieldValue(value) {
+		return fmt.Errorf("error: invalid set-cookie value %q", value)
+	}
+	w.cookies = append(w.cookies, value)
 	return nil
 }
 
+// Cookies returns the Set-Cookie field values queued with SetCookie and
+// AddSetCookie
+func (w *Writer) Cookies() []string {
+	return w.cookies
+}
+
 func (w *Writer) WriteTrailers(headers headers.Headers) error {
-	for key, val := range headers {
-		_, err := w.ResponseWriter.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, val)))
-		if err != nil {
-			return fmt.Errorf("error writing trailer: %s %s: %s", key, val, err)
-		}
+	if !w.bodyAllowed() {
+		return nil
 	}
-	_, err := w.ResponseWriter.Write([]byte("\r\n"))
+	err := w.writeFields(headers, nil)
+	if err != nil {
+		return err
+	}
+	_, err = w.ResponseWriter.Write([]byte("\r\n"))
 	if err != nil {
 		return fmt.Errorf("error writing final CLRF: %s", err)
 	}
+	if w.chunksDone {
+		w.complete = true
+	}
 
 	return nil
 }
 
+// writeFields validates every field before writing any of them, so a bad
+// name or value can't leave a half written or split response on the wire.
+// Fields are written sorted by canonical name so output is deterministic
+func (w *Writer) writeFields(fields headers.Headers, cookies []string) error {
+	keys, err := fields.SortedKeys()
+	if err != nil {
+		return err
+	}
+
+	var block strings.Builder
+	writeCookies := func() {
+		for _, c := range cookies {
+			block.WriteString(fmt.Sprintf("Set-Cookie: %s\r\n", c))
+		}
+		cookies = nil
+	}
+	for _, key := range keys {
+		name := key
+		if !w.PreserveHeaderCase {
+			name = headers.CanonicalKey(key)
+		}
+		if headers.CanonicalKey(key) > "Set-Cookie" {
+			writeCookies()
+		}
+		block.WriteString(fmt.Sprintf("%s: %s\r\n", name, fields[key]))
+	}
+	writeCookies()
+
+	_, err = w.ResponseWriter.Write([]byte(block.String()))
+	if err != nil {
+		return fmt.Errorf("error writing fields: %s", err)
+	}
+	return nil
+}
+
+// requestMethod is the method of the request being answered, empty if it
+// isn't known
+func (w *Writer) requestMethod() string {
+	if w.Request == nil {
+		return ""
+	}
+	return w.Request.RequestLine.Method
+}
+
+func (w *Writer) bodyAllowed() bool {
+	if w.requestMethod() == "HEAD" {
+		return false
+	}
+	return w.Status != Code204 && w.Status != Code304 && (w.Status < 100 || w.Status > 199)
+}
+
+// WriteBody writes w.Body. When the response is being compressed, the count
+// returned is of the uncompressed bytes consumed
 func (w *Writer) WriteBody() (int, error) {
+	if !w.bodyAllowed() {
+		w.complete = true
+		return 0, nil
+	}
+	if c := w.compressor; c != nil {
+		if c.compressed != nil {
+			_, err := w.ResponseWriter.Write(c.compressed)
+			if err != nil {
+				return 0, err
+			}
+			w.complete = true
+			return len(w.Body), nil
+		}
+		err := c.write(w.Body)
+		if err == nil {
+			err = c.close()
+		}
+		if err == nil && c.framing == framingChunked {
+			_, err = w.ResponseWriter.Write([]byte("0\r\n\r\n"))
+		}
+		if err != nil {
+			return 0, err
+		}
+		w.complete = true
+		return len(w.Body), nil
+	}
 	numBytes, err := w.ResponseWriter.Write(w.Body)
 	if err != nil {
 		return numBytes, err
 	}
+	w.complete = true
 	return numBytes, nil
 }
 
+// WriteChunkedBody writes p as a chunk. What's streamed this way isn't kept
+// unless CaptureBody was called first
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !w.bodyAllowed() {
+		w.capture(p)
+		return 0, nil
+	}
+	if w.compressor != nil {
+		err := w.compressor.write(p)
+		if err != nil {
+			return 0, err
+		}
+		w.capture(p)
+		return len(p), nil
+	}
+
+	numBytes, err := w.writeChunk(p)
+	if err != nil {
+		return 0, err
+	}
+	w.capture(p)
+	return numBytes, nil
+}
+
+// CaptureBody keeps a copy of what WriteChunkedBody writes from then on, up
+// to limit bytes, for CapturedBody to return. A body outgrowing limit is
+// dropped rather than held in full
+func (w *Writer) CaptureBody(limit int) {
+	w.captureLimit = limit
+}
+
+// CapturedBody returns the whole body written, and whether it's known: it is
+// for a body written from w.Body with WriteBody, or streamed with
+// WriteChunkedBody while being captured and within the limit
+func (w *Writer) CapturedBody() ([]byte, bool) {
+	if !w.streamed {
+		return w.Body, true
+	}
+	if w.captureLimit <= 0 || w.captureOverflowed {
+		return nil, false
+	}
+	return w.captured, true
+}
+
+func (w *Writer) capture(p []byte) {
+	w.streamed = true
+	if w.captureLimit <= 0 || w.captureOverflowed {
+		return
+	}
+	if len(w.captured)+len(p) > w.captureLimit {
+		w.captureOverflowed = true
+		w.captured = nil
+		return
+	}
+	w.captured = append(w.captured, p...)
+}
+
+func (w *Writer) writeChunk(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
@@ -98,14 +447,23 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if err != nil {
 		return 0, fmt.Errorf("error writing byte chunk to response writer")
 	}
-	w.Body = append(w.Body, p...)
 	return numBytesHex + numBytesMain + numBytesCLRF, nil
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if !w.bodyAllowed() {
+		return 0, nil
+	}
+	if w.compressor != nil {
+		err := w.compressor.close()
+		if err != nil {
+			return 0, err
+		}
+	}
 	numBytes, err := w.ResponseWriter.Write([]byte("0\r\n"))
 	if err != nil {
 		return 0, fmt.Errorf("error writing final 0 chunk to response")
 	}
+	w.chunksDone = true
 	return numBytes, nil
 }
@@ -0,0 +1,148 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHeaders(t *testing.T) {
+	// Test: Valid headers
+	buf := &bytes.Buffer{}
+	w := Writer{ResponseWriter: buf}
+	err := w.WriteHeaders(headers.Headers{"Content-Length": "0"})
+	require.NoError(t, err)
+	assert.Equal(t, "Content-Length: 0\r\n\r\n", buf.String())
+
+	// Test: CRLF injection in value
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf}
+	err = w.WriteHeaders(headers.Headers{"X-Echo": "hi\r\nSet-Cookie: admin=1"})
+	require.Error(t, err)
+	assert.Equal(t, "", buf.String())
+
+	// Test: Bare LF and NUL in value
+	for _, val := range []string{"hi\nthere", "hi\rthere", "hi\x00there"} {
+		buf = &bytes.Buffer{}
+		w = Writer{ResponseWriter: buf}
+		err = w.WriteHeaders(headers.Headers{"X-Echo": val})
+		require.Error(t, err)
+		assert.Equal(t, "", buf.String())
+	}
+
+	// Test: Invalid field name
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf}
+	err = w.WriteHeaders(headers.Headers{"X Bad:": "value"})
+	require.Error(t, err)
+	assert.Equal(t, "", buf.String())
+
+	// Test: The same field under names differing only in case
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf}
+	err = w.WriteHeaders(headers.Headers{"content-length": "0", "Content-Length": "42"})
+	require.Error(t, err)
+	assert.Equal(t, "", buf.String())
+
+	// Test: CRLF injection in trailer
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf}
+	err = w.WriteTrailers(headers.Headers{"X-Content-Sha256": "abc\r\n\r\nextra"})
+	require.Error(t, err)
+	assert.Equal(t, "", buf.String())
+}
+
+func TestWriteHeadersOrder(t *testing.T) {
+	// Test: Headers are sorted and canonicalized
+	buf := &bytes.Buffer{}
+	w := Writer{ResponseWriter: buf}
+	err := w.WriteHeaders(headers.Headers{
+		"content-type":   "text/html",
+		"CONNECTION":     "close",
+		"Content-Length": "42",
+		"x-request-id":   "abc",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Connection: close\r\n"+
+		"Content-Length: 42\r\n"+
+		"Content-Type: text/html\r\n"+
+		"X-Request-Id: abc\r\n"+
+		"\r\n", buf.String())
+
+	// Test: Output is the same on every call
+	for i := 0; i < 20; i++ {
+		again := &bytes.Buffer{}
+		w = Writer{ResponseWriter: again}
+		err = w.WriteHeaders(headers.Headers{
+			"content-type":   "text/html",
+			"CONNECTION":     "close",
+			"Content-Length": "42",
+			"x-request-id":   "abc",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, buf.String(), again.String())
+	}
+
+	// Test: Handler casing is kept when asked for
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf, PreserveHeaderCase: true}
+	err = w.WriteHeaders(headers.Headers{
+		"content-type": "text/html",
+		"X-ETAG-Hash":  "abc",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "content-type: text/html\r\nX-ETAG-Hash: abc\r\n\r\n", buf.String())
+
+	// Test: Set-Cookie lines are slotted into the sorted order
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf}
+	w.cookies = []string{"a=1", "b=2"}
+	err = w.WriteHeaders(headers.Headers{"x-after": "1", "content-length": "0"})
+	require.NoError(t, err)
+	assert.Equal(t, "Content-Length: 0\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\nX-After: 1\r\n\r\n", buf.String())
+}
+
+func TestWriteHeadersVary(t *testing.T) {
+	// Test: Negotiated request headers are added to Vary
+	req := &request.Request{Headers: headers.Headers{"accept": "application/json"}}
+	req.NegotiateContentType("text/html", "application/json")
+	req.NegotiateLanguage("en")
+	buf := &bytes.Buffer{}
+	w := Writer{ResponseWriter: buf, Request: req}
+	err := w.WriteHeaders(headers.Headers{"Content-Type": "application/json", "vary": "Cookie"})
+	require.NoError(t, err)
+	assert.Equal(t, "Content-Type: application/json\r\nVary: Cookie, Accept, Accept-Language\r\n\r\n", buf.String())
+
+	// Test: No negotiation leaves headers untouched
+	buf = &bytes.Buffer{}
+	w = Writer{ResponseWriter: buf, Request: &request.Request{Headers: headers.NewHeaders()}}
+	err = w.WriteHeaders(headers.Headers{"Content-Length": "0"})
+	require.NoError(t, err)
+	assert.Equal(t, "Content-Length: 0\r\n\r\n", buf.String())
+}
+
+func TestWriteChunkedBodyCapture(t *testing.T) {
+	// Test: Streamed chunks aren't kept by default
+	w := &Writer{ResponseWriter: &bytes.Buffer{}}
+	w.WriteHeaders(headers.Headers{"Transfer-Encoding": "chunked"})
+	w.WriteChunkedBody([]byte("hello"))
+	assert.Empty(t, w.Body)
+	_, ok := w.CapturedBody()
+	assert.False(t, ok)
+
+	// Test: A captured body is kept up to the limit, and dropped past it
+	w = &Writer{ResponseWriter: &bytes.Buffer{}}
+	w.CaptureBody(8)
+	w.WriteHeaders(headers.Headers{"Transfer-Encoding": "chunked"})
+	w.WriteChunkedBody([]byte("hello"))
+	body, ok := w.CapturedBody()
+	assert.True(t, ok)
+	assert.Equal(t, "hello", string(body))
+	w.WriteChunkedBody([]byte(" world"))
+	_, ok = w.CapturedBody()
+	assert.False(t, ok)
+}
@@ -0,0 +1,183 @@
+package response
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+)
+
+// Event is one Server-Sent Event. Data may span several lines, and Retry,
+// when set, tells the client how long to wait before reconnecting
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// EventStream sends Server-Sent Events as a chunked response, each event in
+// a chunk of its own so it reaches the client straight away. LastEventID is
+// the ID of the last event a reconnecting client saw, from its Last-Event-ID
+// header, for picking the stream back up after it. Done is closed once the
+// stream is closed, a write fails or the request's context ends, which is
+// when the client goes away, the server shuts down or the request times out
+type EventStream struct {
+	LastEventID string
+	w           *Writer
+	mu          sync.Mutex
+	closed      bool
+	done        chan struct{}
+	err         error
+	stopWatch   func() bool
+}
+
+// StartEventStream answers with a text/event-stream response. When
+// heartbeat is set a comment is sent that often, which keeps intermediaries
+// from timing out a quiet stream and notices a client that's gone
+func (w *Writer) StartEventStream(heartbeat time.Duration) (*EventStream, error) {
+	s := &EventStream{w: w, done: make(chan struct{})}
+	ctx := context.Background()
+	if w.Request != nil {
+		s.LastEventID = w.Request.Headers.Get("last-event-id")
+		ctx = w.Request.Context()
+	}
+
+	err := w.WriteStatusLine()
+	if err != nil {
+		return nil, err
+	}
+	err = w.WriteHeaders(headers.Headers{
+		"Content-Type":      "text/event-stream",
+		"Cache-Control":     "no-cache",
+		"Transfer-Encoding": "chunked",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Held so an already ended context can't get to end before stopWatch is set
+	s.mu.Lock()
+	s.stopWatch = context.AfterFunc(ctx, func() {
+		s.end(ctx.Err())
+	})
+	s.mu.Unlock()
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	}
+	return s, nil
+}
+
+func (s *EventStream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.Comment("heartbeat")
+		}
+	}
+}
+
+// Send writes an event. An event with neither Data nor Event set only
+// updates the client's last event ID or reconnection time
+func (s *EventStream) Send(e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return fmt.Errorf("error: invalid event id %q", e.ID)
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return fmt.Errorf("error: invalid event name %q", e.Event)
+	}
+
+	var b strings.Builder
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	if e.Data != "" || e.Event != "" {
+		// Each line becomes a data field of its own, rejoined by the client
+		for _, line := range splitLines(e.Data) {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore
+func (s *EventStream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(": " + line + "\n")
+	}
+	return s.write(b.String())
+}
+
+// splitLines splits on any of the three line endings the event stream
+// format recognises, since each would end a field early
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.Split(text, "\n")
+}
+
+func (s *EventStream) write(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		if s.err != nil {
+			return s.err
+		}
+		return fmt.Errorf("error: event stream is closed")
+	}
+
+	_, err := s.w.WriteChunkedBody([]byte(p))
+	if err != nil {
+		s.err = err
+		s.closed = true
+		close(s.done)
+		s.stopWatch()
+		return err
+	}
+	return nil
+}
+
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the response, unless the stream already ended with a failed
+// write, and stops the heartbeat
+func (s *EventStream) Close() error {
+	return s.end(nil)
+}
+
+// end finishes the stream, with cause as the error later writes return
+func (s *EventStream) end(cause error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.err = cause
+	close(s.done)
+	s.stopWatch()
+
+	_, err := s.w.WriteChunkedBodyDone()
+	if err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(nil)
+}
@@ -0,0 +1,114 @@
+package response
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// brokenWriter fails every write once the client has gone
+type brokenWriter struct {
+	buf  bytes.Buffer
+	gone bool
+}
+
+func (b *brokenWriter) Write(p []byte) (int, error) {
+	if b.gone {
+		return 0, errors.New("broken pipe")
+	}
+	return b.buf.Write(p)
+}
+
+func TestEventStream(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET /events HTTP/1.1\r\nHost: localhost\r\nLast-Event-ID: 41\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Events are encoded one per chunk, multi-line data split into fields
+	buf := &bytes.Buffer{}
+	w := &Writer{ResponseWriter: buf, Request: req}
+	s, err := w.StartEventStream(0)
+	require.NoError(t, err)
+	assert.Equal(t, "41", s.LastEventID)
+	require.NoError(t, s.Send(Event{ID: "42", Event: "update", Data: "line one\nline two\r\nline three\rend"}))
+	require.NoError(t, s.Send(Event{Data: ""}))
+	require.NoError(t, s.Send(Event{Retry: 1500 * time.Millisecond}))
+	require.NoError(t, s.Comment("note"))
+	require.NoError(t, s.Close())
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("stream not done after Close")
+	}
+
+	resp, err := ResponseFromReader(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "text/event-stream", resp.Headers.Get("content-type"))
+	assert.Equal(t, "no-cache", resp.Headers.Get("cache-control"))
+	assert.Equal(t, "event: update\nid: 42\ndata: line one\ndata: line two\ndata: line three\ndata: end\n\n"+
+		"\n"+
+		"retry: 1500\n\n"+
+		": note\n", string(resp.Body))
+	assert.Empty(t, w.Body)
+
+	// Test: Fields that would break the framing are refused
+	w = &Writer{ResponseWriter: &bytes.Buffer{}}
+	s, err = w.StartEventStream(0)
+	require.NoError(t, err)
+	assert.Error(t, s.Send(Event{ID: "1\n2", Data: "x"}))
+	assert.Error(t, s.Send(Event{Event: "a\rb", Data: "x"}))
+	s.Close()
+	assert.Error(t, s.Send(Event{Data: "late"}))
+
+	// Test: Heartbeats are sent while the stream is quiet
+	buf = &bytes.Buffer{}
+	w = &Writer{ResponseWriter: buf}
+	s, err = w.StartEventStream(10 * time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	s.Close()
+	resp, err = ResponseFromReader(buf)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp.Body), ": heartbeat\n")
+
+	// Test: The request's context ending ends the response
+	ctx, cancel := context.WithCancel(context.Background())
+	buf = &bytes.Buffer{}
+	w = &Writer{ResponseWriter: buf, Request: req.WithContext(ctx)}
+	s, err = w.StartEventStream(0)
+	require.NoError(t, err)
+	require.NoError(t, s.Send(Event{Data: "before"}))
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream not done after the context ended")
+	}
+	assert.ErrorIs(t, s.Send(Event{Data: "after"}), context.Canceled)
+	assert.NoError(t, s.Close())
+	resp, err = ResponseFromReader(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "data: before\n\n", string(resp.Body))
+
+	// Test: A heartbeat to a client that's gone ends the stream
+	broken := &brokenWriter{}
+	w = &Writer{ResponseWriter: broken}
+	s, err = w.StartEventStream(10 * time.Millisecond)
+	require.NoError(t, err)
+	s.mu.Lock()
+	broken.gone = true
+	s.mu.Unlock()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream not done after the client went away")
+	}
+	assert.Error(t, s.Send(Event{Data: "too late"}))
+	assert.NoError(t, s.Close())
+}
@@ -0,0 +1,15 @@
+package server
+
+import (
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+)
+
+// Compress wraps a handler so its responses are gzip or deflate encoded
+// when the client's Accept-Encoding allows it
+func Compress(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.EnableCompression(req.Headers.Get("accept-encoding"))
+		next(w, req)
+	}
+}
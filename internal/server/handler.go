@@ -7,6 +7,12 @@ import (
 	"github.com/jms-guy/httpfromtcp/internal/response"
 )
 
+// Handler answers a request. The server reads the body into req.Body before
+// calling it, except when the request was sent with Expect: 100-continue or
+// Config.StreamBodies is set: then Body is empty until the handler reads the
+// body with req.ReadBody, req.BodyReader or req.ParseForm, the first of which
+// tells a client waiting on 100-continue to send it. BodyErrorStatus gives
+// the status to answer with if that fails
 type Handler func(w *response.Writer, req *request.Request)
 
 type HandlerError struct {
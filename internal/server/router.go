@@ -0,0 +1,124 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+)
+
+// Router dispatches requests on exact path and method. HEAD requests for a
+// path with no HEAD handler are served by its GET handler, with the writer
+// dropping the body, and OPTIONS requests are answered from the routing
+// table, with OPTIONS * and OPTIONS / on an unrouted root listing every
+// method the server has a route for. Paths with no routes go to NotFound,
+// or get a plain 404. CONNECT requests and absolute-form targets go to
+// Proxy when it is set, before any path is matched
+type Router struct {
+	NotFound Handler
+	Proxy    Handler
+	routes   map[string]map[string]Handler
+}
+
+func NewRouter() *Router {
+	return &Router{routes: make(map[string]map[string]Handler)}
+}
+
+func (rt *Router) Handle(method, path string, handler Handler) {
+	if rt.routes[path] == nil {
+		rt.routes[path] = make(map[string]Handler)
+	}
+	rt.routes[path][method] = handler
+}
+
+func (rt *Router) Route(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	if rt.Proxy != nil && (method == "CONNECT" || absoluteForm(req.RequestLine.RequestTarget)) {
+		rt.Proxy(w, req)
+		return
+	}
+	path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	_, rootRouted := rt.routes["/"]
+	if method == "OPTIONS" && (path == "*" || (path == "/" && !rootRouted)) {
+		all := make(map[string]Handler)
+		for _, methods := range rt.routes {
+			for name, handler := range methods {
+				all[name] = handler
+			}
+		}
+		writeEmpty(w, response.Code200, headers.Headers{"Allow": allowed(all)})
+		return
+	}
+
+	methods, ok := rt.routes[path]
+	if !ok && method == "OPTIONS" {
+		writeEmpty(w, response.Code404, nil)
+		return
+	}
+	if !ok {
+		if rt.NotFound != nil {
+			rt.NotFound(w, req)
+			return
+		}
+		writeEmpty(w, response.Code404, nil)
+		return
+	}
+
+	if handler, ok := methods[method]; ok {
+		handler(w, req)
+		return
+	}
+	if handler, ok := methods["GET"]; ok && method == "HEAD" {
+		handler(w, req)
+		return
+	}
+	if method == "OPTIONS" {
+		writeEmpty(w, response.Code200, headers.Headers{"Allow": allowed(methods)})
+		return
+	}
+
+	writeEmpty(w, response.Code405, headers.Headers{"Allow": allowed(methods)})
+}
+
+// allowed lists the registered methods along with the HEAD and OPTIONS
+// methods the router answers on their behalf
+func allowed(methods map[string]Handler) string {
+	names := make([]string, 0, len(methods)+2)
+	for method := range methods {
+		names = append(names, method)
+	}
+	if _, ok := methods["GET"]; ok {
+		if _, ok := methods["HEAD"]; !ok {
+			names = append(names, "HEAD")
+		}
+	}
+	if _, ok := methods["OPTIONS"]; !ok {
+		names = append(names, "OPTIONS")
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// absoluteForm reports whether a request target is a full URL, as sent to a
+// forward proxy, rather than a path
+func absoluteForm(target string) bool {
+	lower := strings.ToLower(target)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+func writeEmpty(w *response.Writer, status response.StatusCode, extra headers.Headers) {
+	h := headers.Headers{}
+	// A 204 can't carry Content-Length, its lack of body is implied
+	if status != response.Code204 {
+		h["Content-Length"] = "0"
+	}
+	for key, val := range extra {
+		h.Del(key)
+		h[key] = val
+	}
+	w.Status = status
+	w.WriteStatusLine()
+	w.WriteHeaders(h)
+}
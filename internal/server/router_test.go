@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/jms-guy/httpfromtcp/internal/server"
+	"github.com/jms-guy/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	rt := server.NewRouter()
+	rt.Handle("GET", "/hello", func(w *response.Writer, req *request.Request) {
+		w.Body = []byte("hello")
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": "5"})
+		w.WriteBody()
+	})
+	rt.Handle("POST", "/hello", func(w *response.Writer, req *request.Request) {
+		w.Status = response.Code204
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{})
+		w.WriteBody()
+	})
+	rt.Handle("GET", "/stream", func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Transfer-Encoding": "chunked"})
+		w.WriteChunkedBody([]byte("abc"))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(nil)
+	})
+
+	// Test: GET route
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello", servertest.Do(rt.Route, "GET", "/hello", nil))
+
+	// Test: Query string is ignored when matching
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello", servertest.Do(rt.Route, "GET", "/hello?name=go", nil))
+
+	// Test: HEAD is served by GET handler without a body
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", servertest.Do(rt.Route, "HEAD", "/hello", nil))
+
+	// Test: HEAD drops chunks and trailers
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n", servertest.Do(rt.Route, "HEAD", "/stream", nil))
+
+	// Test: 204 never carries a body
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", servertest.Do(rt.Route, "POST", "/hello", nil))
+
+	// Test: Unregistered method
+	assert.Equal(t, "HTTP/1.1 405 Method Not Allowed\r\nAllow: GET, HEAD, OPTIONS, POST\r\nContent-Length: 0\r\n\r\n", servertest.Do(rt.Route, "DELETE", "/hello", nil))
+
+	// Test: Unregistered path
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n", servertest.Do(rt.Route, "GET", "/missing", nil))
+}
+
+func TestRouterOptions(t *testing.T) {
+	rt := server.NewRouter()
+	noop := func(w *response.Writer, req *request.Request) {}
+	rt.Handle("GET", "/hello", noop)
+	rt.Handle("POST", "/hello", noop)
+	rt.Handle("PUT", "/upload", noop)
+	rt.NotFound = func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": "0"})
+	}
+
+	// Test: OPTIONS on a path lists its methods
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nAllow: GET, HEAD, OPTIONS, POST\r\nContent-Length: 0\r\n\r\n", servertest.Do(rt.Route, "OPTIONS", "/hello", nil))
+
+	// Test: OPTIONS * lists every registered method
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nAllow: GET, HEAD, OPTIONS, POST, PUT\r\nContent-Length: 0\r\n\r\n", servertest.Do(rt.Route, "OPTIONS", "*", nil))
+
+	// Test: OPTIONS / with nothing routed at the root lists every method too
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nAllow: GET, HEAD, OPTIONS, POST, PUT\r\nContent-Length: 0\r\n\r\n", servertest.Do(rt.Route, "OPTIONS", "/", nil))
+
+	// Test: OPTIONS on an unregistered path skips NotFound
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n", servertest.Do(rt.Route, "OPTIONS", "/missing", nil))
+
+	// Test: Registered OPTIONS handler takes precedence
+	rt.Handle("OPTIONS", "/upload", func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": "0", "X-Custom": "yes"})
+	})
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nX-Custom: yes\r\n\r\n", servertest.Do(rt.Route, "OPTIONS", "/upload", nil))
+}
+
+func TestRouterProxy(t *testing.T) {
+	rt := server.NewRouter()
+	rt.Handle("GET", "/hello", func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": "0"})
+	})
+
+	// Test: Without a Proxy an absolute-form target is matched as a path
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n", servertest.Do(rt.Route, "GET", "http://example.com/hello", nil))
+
+	rt.Proxy = func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine()
+		w.WriteHeaders(headers.Headers{"Content-Length": "0", "X-Target": req.RequestLine.RequestTarget})
+	}
+
+	// Test: CONNECT goes to Proxy
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nX-Target: example.com:443\r\n\r\n", servertest.Do(rt.Route, "CONNECT", "example.com:443", nil))
+
+	// Test: Absolute-form target goes to Proxy
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nX-Target: http://example.com/hello\r\n\r\n", servertest.Do(rt.Route, "GET", "http://example.com/hello", nil))
+
+	// Test: Origin-form paths are still routed
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", servertest.Do(rt.Route, "GET", "/hello", nil))
+}
@@ -1,10 +1,17 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/jms-guy/httpfromtcp/internal/headers"
 	"github.com/jms-guy/httpfromtcp/internal/request"
 	"github.com/jms-guy/httpfromtcp/internal/response"
 )
@@ -12,23 +19,49 @@ import (
 type Server struct {
 	Listener net.Listener
 	Handler  Handler
+	Config   Config
 	isClosed atomic.Bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+type Config struct {
+	// Unfold obsolete line folding in request headers rather than
+	// rejecting the request with a 400
+	AllowObsFold bool
+	// Largest a gzip or deflate request body may grow to once decoded,
+	// zero uses the request package default
+	MaxDecompressedSize int64
+	// How long a handler has before its request's context is cancelled,
+	// zero for no limit
+	RequestTimeout time.Duration
+	// Leave request bodies unread for the handler to read or stream, as
+	// with Expect: 100-continue, rather than reading them in full first
+	StreamBodies bool
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithConfig(port, handler, Config{})
+}
+
+func ServeWithConfig(port int, handler Handler, config Config) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, fmt.Errorf("error starting tcp listener")
 	}
 
-	newServer := Server{Listener: listener, Handler: handler}
+	ctx, cancel := context.WithCancel(context.Background())
+	newServer := Server{Listener: listener, Handler: handler, Config: config, ctx: ctx, cancel: cancel}
 
 	go newServer.listen()
 
 	return &newServer, nil
 }
 
+// Close stops accepting connections and cancels the context of every
+// request still being handled
 func (s *Server) Close() error {
+	s.cancel()
 	err := s.Listener.Close()
 	if err != nil {
 		return fmt.Errorf("error closing server tcp listener")
@@ -53,13 +86,151 @@ func (s *Server) listen() {
 }
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	req, err := request.RequestFromReader(conn)
+	var hijacked atomic.Bool
+	defer func() {
+		if !hijacked.Load() {
+			conn.Close()
+		}
+	}()
+	resp := response.Writer{ResponseWriter: conn}
+	continueReader := &expectContinueReader{reader: conn, w: &resp}
+
+	reader := request.NewReader(continueReader)
+	reader.AllowObsFold = s.Config.AllowObsFold
+	reader.MaxDecompressedSize = s.Config.MaxDecompressedSize
+	var watcher *disconnectWatcher
+	resp.Hijacker = func() (net.Conn, []byte, error) {
+		hijacked.Store(true)
+		buffered := reader.TakeBuffered()
+		if watcher != nil {
+			buffered = append(buffered, watcher.stop()...)
+		}
+		return conn, buffered, nil
+	}
+
+	req, err := reader.ReadHead()
 	if err != nil {
 		fmt.Println(err)
+		reject(&resp, BodyErrorStatus(err))
+		return
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if s.Config.RequestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(s.ctx, s.Config.RequestTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(s.ctx)
+	}
+	defer cancel()
+	req = req.WithContext(ctx)
+	req.RemoteAddr = conn.RemoteAddr().String()
+	resp.Request = req
+
+	switch strings.ToLower(req.Headers.Get("expect")) {
+	case "":
+		if s.Config.StreamBodies && hasBody(req) {
+			// As below, there's no watching for a disconnect while the
+			// handler has the body still to read
+			break
+		}
+		_, err = req.ReadBody()
+		if err != nil {
+			fmt.Println(err)
+			reject(&resp, BodyErrorStatus(err))
+			return
+		}
+		watcher = watchDisconnect(conn, cancel)
+	case "100-continue":
+		// The body is left unread until the handler asks for it, which is
+		// when the client gets told to go ahead and send it. Reading ahead
+		// for a disconnect would take body bytes, so none is watched for
+		continueReader.armed = true
+	default:
+		reject(&resp, response.Code417)
 		return
 	}
-	resp := response.Writer{ResponseWriter: conn}
 
 	s.Handler(&resp, req)
 }
+
+// disconnectWatcher reads from a connection whose request has been read in
+// full, so the request's context is cancelled as soon as the client closes
+// it rather than when a write fails
+type disconnectWatcher struct {
+	conn    net.Conn
+	stopped atomic.Bool
+	done    chan struct{}
+	extra   []byte
+}
+
+func watchDisconnect(conn net.Conn, cancel context.CancelFunc) *disconnectWatcher {
+	w := &disconnectWatcher{conn: conn, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		var b [1]byte
+		n, err := conn.Read(b[:])
+		if n > 0 {
+			// The client sent more, which has to be kept for a hijacker, so
+			// there's no telling when it goes away from here on
+			w.extra = b[:n]
+			return
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) && w.stopped.Load() {
+			return
+		}
+		cancel()
+	}()
+	return w
+}
+
+// stop ends the background read, returning any byte it took from the
+// connection
+func (w *disconnectWatcher) stop() []byte {
+	w.stopped.Store(true)
+	w.conn.SetReadDeadline(time.Unix(1, 0))
+	<-w.done
+	w.conn.SetReadDeadline(time.Time{})
+	return w.extra
+}
+
+func hasBody(req *request.Request) bool {
+	contentLength := req.Headers.Get("content-length")
+	return contentLength != "" && contentLength != "0"
+}
+
+// BodyErrorStatus is the status to answer with when reading a request body
+// failed, the same one the server answers with when it reads the body itself.
+// A body sent with a Transfer-Encoding rather than a Content-Length gets 411
+func BodyErrorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrTransferEncoding):
+		return response.Code411
+	case errors.Is(err, request.ErrUnsupportedEncoding):
+		return response.Code415
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.Code413
+	default:
+		return response.Code400
+	}
+}
+
+// reject answers a request the server won't pass on to the handler
+func reject(w *response.Writer, status response.StatusCode) {
+	writeEmpty(w, status, headers.Headers{"Connection": "close"})
+}
+
+type expectContinueReader struct {
+	reader io.Reader
+	w      *response.Writer
+	armed  bool
+}
+
+func (e *expectContinueReader) Read(p []byte) (int, error) {
+	if e.armed {
+		e.armed = false
+		// Fails harmlessly if the handler already sent its final response
+		e.w.WriteInformational(response.Code100, nil)
+	}
+	return e.reader.Read(p)
+}
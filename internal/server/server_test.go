@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestContext(t *testing.T) {
+	ended := make(chan error, 1)
+	waitForEnd := func(w *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			ended <- req.Context().Err()
+		case <-time.After(5 * time.Second):
+			ended <- nil
+		}
+	}
+	send := func(s *Server, raw string) net.Conn {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", s.Listener.Addr().(*net.TCPAddr).Port))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+		return conn
+	}
+	get := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: The client going away cancels the context
+	s, err := Serve(0, waitForEnd)
+	require.NoError(t, err)
+	defer s.Close()
+	conn := send(s, get)
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	assert.Equal(t, context.Canceled, <-ended)
+
+	// Test: Shutting the server down cancels the context
+	send(s, get)
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+	assert.Equal(t, context.Canceled, <-ended)
+
+	// Test: The request timeout ends the context
+	s, err = ServeWithConfig(0, waitForEnd, Config{RequestTimeout: 20 * time.Millisecond})
+	require.NoError(t, err)
+	defer s.Close()
+	send(s, get)
+	assert.Equal(t, context.DeadlineExceeded, <-ended)
+
+	// Test: A hijacked connection is handed over with everything sent after
+	// the request, even bytes read while watching for a disconnect
+	hijacked := make(chan string, 1)
+	s, err = Serve(0, func(w *response.Writer, req *request.Request) {
+		time.Sleep(20 * time.Millisecond)
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			hijacked <- err.Error()
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		rest := make([]byte, len("after the request")-len(buffered))
+		_, err = io.ReadFull(conn, rest)
+		if err != nil {
+			hijacked <- err.Error()
+			return
+		}
+		hijacked <- string(buffered) + string(rest)
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	send(s, get+"after the request")
+	assert.Equal(t, "after the request", <-hijacked)
+}
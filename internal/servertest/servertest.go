@@ -0,0 +1,63 @@
+// Package servertest runs handlers for tests, either directly against a
+// recorded writer or behind a real server
+package servertest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/jms-guy/httpfromtcp/internal/server"
+)
+
+// NewRequest builds a bodiless request, with h as its fields if given
+func NewRequest(method, target string, h headers.Headers) *request.Request {
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     h,
+	}
+}
+
+// ReadRequest parses the head of raw, leaving any body to be read by the
+// handler
+func ReadRequest(t testing.TB, raw string) *request.Request {
+	req, err := request.NewReader(strings.NewReader(raw)).ReadHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// Record runs handler on req and returns everything it wrote
+func Record(handler server.Handler, req *request.Request) string {
+	buf := &strings.Builder{}
+	handler(&response.Writer{ResponseWriter: buf, Request: req}, req)
+	return buf.String()
+}
+
+// Do runs handler on a bodiless request and returns everything it wrote
+func Do(handler server.Handler, method, target string, h headers.Headers) string {
+	return Record(handler, NewRequest(method, target, h))
+}
+
+// Serve runs handler behind a server on a free port for the rest of the
+// test, and returns the server's base URL
+func Serve(t testing.TB, handler server.Handler) string {
+	return ServeWithConfig(t, handler, server.Config{})
+}
+
+func ServeWithConfig(t testing.TB, handler server.Handler, config server.Config) string {
+	s, err := server.ServeWithConfig(0, handler, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return fmt.Sprintf("http://127.0.0.1:%d", s.Listener.Addr().(*net.TCPAddr).Port)
+}
@@ -0,0 +1,62 @@
+// Package testutil holds test helpers that only need the standard library,
+// so the tests of any package can use them
+package testutil
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// ChunkReader reads Data NumBytesPerRead bytes at a time, simulating a
+// connection delivering a message in pieces
+type ChunkReader struct {
+	Data            string
+	NumBytesPerRead int
+	pos             int
+}
+
+func (cr *ChunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.Data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.NumBytesPerRead
+	if endIndex > len(cr.Data) {
+		endIndex = len(cr.Data)
+	}
+	n = copy(p, cr.Data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+// Listen accepts connections on a free local port for the rest of the test,
+// handing each to handle on its own goroutine, and returns the address
+func Listen(t testing.TB, handle func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// ClosedAddr returns a local address nothing is listening on
+func ClosedAddr(t testing.TB) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
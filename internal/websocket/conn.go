@@ -0,0 +1,420 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes, RFC 6455 section 7.4.1
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+const (
+	defaultMaxMessageSize = 16 << 20
+	maxControlPayload     = 125
+	closeTimeout          = 5 * time.Second
+)
+
+var errClosing = errors.New("error: websocket connection is closing")
+
+// CloseError ends a connection, either closed by the peer with Code and Text
+// or failed by this end for breaking the protocol
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("websocket closed: %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Text)
+}
+
+// Conn exchanges messages over a connection that's completed the opening
+// handshake. Messages larger than MaxMessageSize, once reassembled, fail the
+// connection with 1009, with zero meaning the default. Outgoing messages are
+// split into frames of FragmentSize bytes when it's set. Reads have to come
+// from one goroutine at a time, while writes can come from any
+type Conn struct {
+	MaxMessageSize int64
+	FragmentSize   int
+	conn           net.Conn
+	reader         *bufio.Reader
+	isServer       bool
+	readErr        error
+	writeMu        sync.Mutex
+	closeSent      bool
+}
+
+// NewConn wraps a connection the handshake has been done on, with buffered
+// holding any bytes already read from it past the handshake. The server end
+// expects masked frames from the client and sends its own unmasked, the
+// client end the other way round
+func NewConn(conn net.Conn, buffered []byte, isServer bool) *Conn {
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+	return &Conn{conn: conn, reader: reader, isServer: isServer}
+}
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// ReadMessage returns the next text or binary message, reassembled from its
+// fragments. Pings are answered as they arrive and pongs are dropped. Once
+// the peer closes the connection, or breaks the protocol, the close is
+// answered, the connection is closed and a *CloseError is returned, with
+// every later call returning the same
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	messageType, message, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+		return 0, nil, err
+	}
+	return messageType, message, nil
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var messageType MessageType
+	message := []byte{}
+	for {
+		f, err := c.readFrame(c.maxMessageSize() - int64(len(message)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch f.opcode {
+		case opPing:
+			err = c.writeControl(opPong, f.payload)
+			if err != nil && err != errClosing {
+				c.conn.Close()
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.answerClose(f.payload)
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(&CloseError{Code: CloseProtocolError, Text: "continuation frame without a message to continue"})
+			}
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, c.fail(&CloseError{Code: CloseProtocolError, Text: "new message before the last one finished"})
+			}
+			messageType = MessageType(f.opcode)
+		default:
+			return 0, nil, c.fail(&CloseError{Code: CloseProtocolError, Text: fmt.Sprintf("unknown opcode %d", f.opcode)})
+		}
+
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(&CloseError{Code: CloseInvalidPayload, Text: "text message isn't valid UTF-8"})
+		}
+		return messageType, message, nil
+	}
+}
+
+// readFrame reads one frame, unmasked, failing data frames with payloads
+// over limit
+func (c *Conn) readFrame(limit int64) (frame, error) {
+	var head [2]byte
+	_, err := io.ReadFull(c.reader, head[:])
+	if err != nil {
+		return frame{}, err
+	}
+
+	f := frame{fin: head[0]&0x80 != 0, opcode: head[0] & 0x0f}
+	if head[0]&0x70 != 0 {
+		return frame{}, &CloseError{Code: CloseProtocolError, Text: "reserved bits set without an extension"}
+	}
+	masked := head[1]&0x80 != 0
+	if masked != c.isServer {
+		return frame{}, &CloseError{Code: CloseProtocolError, Text: "frame masking is wrong for its direction"}
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return frame{}, &CloseError{Code: CloseProtocolError, Text: "payload length has its top bit set"}
+		}
+	}
+	if err != nil {
+		return frame{}, err
+	}
+
+	if f.opcode&0x8 != 0 {
+		if !f.fin || length > maxControlPayload {
+			return frame{}, &CloseError{Code: CloseProtocolError, Text: "control frames can't be fragmented or over 125 bytes"}
+		}
+	} else if length > uint64(limit) {
+		return frame{}, &CloseError{Code: CloseMessageTooBig, Text: "message too big"}
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(c.reader, mask[:])
+		if err != nil {
+			return frame{}, err
+		}
+	}
+	f.payload = make([]byte, length)
+	_, err = io.ReadFull(c.reader, f.payload)
+	if err != nil {
+		return frame{}, err
+	}
+	if masked {
+		maskBytes(mask, f.payload)
+	}
+	return f, nil
+}
+
+// answerClose echoes a close frame from the peer and closes the connection
+func (c *Conn) answerClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(&CloseError{Code: CloseProtocolError, Text: "close frame with a one byte payload"})
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(&CloseError{Code: CloseProtocolError, Text: fmt.Sprintf("invalid close code %d", closeErr.Code)})
+		}
+		if !utf8.ValidString(closeErr.Text) {
+			return c.fail(&CloseError{Code: CloseInvalidPayload, Text: "close reason isn't valid UTF-8"})
+		}
+	}
+
+	echo := []byte{}
+	if closeErr.Code != CloseNoStatusReceived {
+		echo = binary.BigEndian.AppendUint16(echo, uint16(closeErr.Code))
+	}
+	c.writeControl(opClose, echo)
+	c.conn.Close()
+	return closeErr
+}
+
+// fail ends the connection after a read error, telling the peer why when
+// the error is its fault
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		c.writeControl(opClose, closePayload(closeErr.Code, closeErr.Text))
+	}
+	c.conn.Close()
+	return err
+}
+
+// Codes a peer may send, RFC 6455 section 7.4
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	default:
+		return false
+	}
+}
+
+// WriteMessage sends a text or binary message, split into FragmentSize
+// frames when that's set
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("error: invalid message type %d", messageType)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return errClosing
+	}
+
+	opcode := byte(messageType)
+	for {
+		size := len(data)
+		if c.FragmentSize > 0 && size > c.FragmentSize {
+			size = c.FragmentSize
+		}
+		fin := size == len(data)
+		err := c.writeFrame(fin, opcode, data[:size])
+		if err != nil {
+			return err
+		}
+		if fin {
+			return nil
+		}
+		data = data[size:]
+		opcode = opContinuation
+	}
+}
+
+// Ping sends a ping, whose pong ReadMessage drops
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("error: ping payload over %d bytes", maxControlPayload)
+	}
+	return c.writeControl(opPing, data)
+}
+
+// Close starts the closing handshake, waiting a short while for the peer to
+// answer before closing the connection. Data messages still arriving in the
+// meantime are dropped. Since it reads, it mustn't be called while a
+// ReadMessage is in progress
+func (c *Conn) Close(code int, text string) error {
+	err := c.writeControl(opClose, closePayload(code, text))
+	if err == errClosing {
+		return c.conn.Close()
+	}
+	if err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	if c.readErr == nil {
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		for {
+			f, err := c.readFrame(c.maxMessageSize())
+			if err != nil || f.opcode == opClose {
+				break
+			}
+		}
+		c.readErr = &CloseError{Code: code, Text: text}
+	}
+	return c.conn.Close()
+}
+
+func (c *Conn) writeControl(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return errClosing
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+	return c.writeFrame(true, opcode, payload)
+}
+
+// writeFrame sends one frame, masked when this is the client end, with the
+// write lock held
+func (c *Conn) writeFrame(fin bool, opcode byte, payload []byte) error {
+	head := make([]byte, 0, 14+len(payload))
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	head = append(head, first)
+
+	var maskBit byte
+	if !c.isServer {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		head = append(head, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		head = append(head, maskBit|126)
+		head = binary.BigEndian.AppendUint16(head, uint16(len(payload)))
+	default:
+		head = append(head, maskBit|127)
+		head = binary.BigEndian.AppendUint64(head, uint64(len(payload)))
+	}
+
+	if c.isServer {
+		_, err := c.conn.Write(append(head, payload...))
+		return err
+	}
+	var mask [4]byte
+	_, err := rand.Read(mask[:])
+	if err != nil {
+		return err
+	}
+	head = append(head, mask[:]...)
+	start := len(head)
+	head = append(head, payload...)
+	maskBytes(mask, head[start:])
+	_, err = c.conn.Write(head)
+	return err
+}
+
+func (c *Conn) maxMessageSize() int64 {
+	if c.MaxMessageSize > 0 {
+		return c.MaxMessageSize
+	}
+	return defaultMaxMessageSize
+}
+
+func closePayload(code int, text string) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, text...)
+	// Control frames are capped at 125 bytes, so long reasons are cut short,
+	// back to a whole character
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+		for !utf8.Valid(payload[2:]) {
+			payload = payload[:len(payload)-1]
+		}
+	}
+	return payload
+}
+
+func maskBytes(mask [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= mask[i%4]
+	}
+}
@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/jms-guy/httpfromtcp/internal/headers"
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+)
+
+// The value appended to the client's key before hashing, RFC 6455 section 1.3
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const supportedVersion = "13"
+
+// Upgrade completes the server side of the opening handshake, RFC 6455
+// section 4.2, answering with 101 Switching Protocols and returning the
+// connection for exchanging messages. A request that isn't a valid handshake
+// is answered with 400, or 426 when it asks for a version other than 13, and
+// an error is returned. The connection is hijacked from the server, so
+// closing it is left to the Conn
+func Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if req.RequestLine.Method != "GET" || req.RequestLine.HttpVersion != "1.1" {
+		reject(w, response.Code400, nil)
+		return nil, fmt.Errorf("error: websocket handshake must be an HTTP/1.1 GET request")
+	}
+	if !headers.HasToken(req.Headers.Get("upgrade"), "websocket") || !headers.HasToken(req.Headers.Get("connection"), "upgrade") {
+		reject(w, response.Code400, nil)
+		return nil, fmt.Errorf("error: request doesn't ask to upgrade to websocket")
+	}
+	if req.Headers.Get("sec-websocket-version") != supportedVersion {
+		reject(w, response.Code426, headers.Headers{"Sec-WebSocket-Version": supportedVersion, "Upgrade": "websocket"})
+		return nil, fmt.Errorf("error: unsupported websocket version %q", req.Headers.Get("sec-websocket-version"))
+	}
+	key := req.Headers.Get("sec-websocket-key")
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		reject(w, response.Code400, nil)
+		return nil, fmt.Errorf("error: invalid Sec-WebSocket-Key %q", key)
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		reject(w, response.Code500, nil)
+		return nil, err
+	}
+	handshake := response.Writer{ResponseWriter: conn, Status: response.Code101}
+	err = handshake.WriteStatusLine()
+	if err == nil {
+		err = handshake.WriteHeaders(headers.Headers{
+			"Upgrade":              "websocket",
+			"Connection":           "Upgrade",
+			"Sec-WebSocket-Accept": AcceptKey(key),
+		})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return NewConn(conn, buffered, true), nil
+}
+
+// AcceptKey is the Sec-WebSocket-Accept value answering a Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func reject(w *response.Writer, status response.StatusCode, extra headers.Headers) {
+	h := headers.Headers{"Content-Length": "0", "Connection": "close"}
+	for key, val := range extra {
+		h[key] = val
+	}
+	w.Status = status
+	w.WriteStatusLine()
+	w.WriteHeaders(h)
+}
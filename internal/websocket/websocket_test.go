@@ -0,0 +1,169 @@
+package websocket
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/httpfromtcp/internal/request"
+	"github.com/jms-guy/httpfromtcp/internal/response"
+	"github.com/jms-guy/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+func TestWebSocket(t *testing.T) {
+	closed := make(chan error, 10)
+	base := servertest.Serve(t, func(w *response.Writer, req *request.Request) {
+		conn, err := Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				closed <- err
+				return
+			}
+			conn.WriteMessage(messageType, message)
+		}
+	})
+	addr := strings.TrimPrefix(base, "http://")
+
+	handshake := func(fields string) (net.Conn, *response.Response) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\nHost: %s\r\n%s\r\n", addr, fields)
+		resp, err := response.NewReader(conn).ReadHead("GET")
+		require.NoError(t, err)
+		return conn, resp
+	}
+	upgradeFields := "Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + testKey + "\r\n"
+
+	// Test: Handshake answers with 101 and the accept key from RFC 6455
+	raw, resp := handshake(upgradeFields)
+	assert.Equal(t, response.Code101, resp.StatusCode)
+	assert.Equal(t, "websocket", resp.Headers.Get("upgrade"))
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Headers.Get("sec-websocket-accept"))
+
+	// Test: Pings are answered with a pong carrying the same payload
+	ws := NewConn(raw, nil, false)
+	require.NoError(t, ws.Ping([]byte("hi")))
+	pong := make([]byte, 4)
+	_, err := io.ReadFull(raw, pong)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x8a, 0x02, 'h', 'i'}, pong)
+
+	// Test: Text and binary messages are echoed back
+	require.NoError(t, ws.WriteMessage(TextMessage, []byte("hello")))
+	messageType, message, err := ws.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, messageType)
+	assert.Equal(t, "hello", string(message))
+	require.NoError(t, ws.WriteMessage(BinaryMessage, []byte{0, 1, 2}))
+	messageType, message, err = ws.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, messageType)
+	assert.Equal(t, []byte{0, 1, 2}, message)
+
+	// Test: Fragmented messages are reassembled, even split inside a character
+	ws.FragmentSize = 3
+	require.NoError(t, ws.WriteMessage(TextMessage, []byte("héllo wörld")))
+	ws.FragmentSize = 0
+	_, message, err = ws.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "héllo wörld", string(message))
+
+	// Test: A pong received is dropped
+	_, err = raw.Write(maskedFrame(0x8a, []byte("unsolicited")))
+	require.NoError(t, err)
+	require.NoError(t, ws.WriteMessage(TextMessage, []byte("after pong")))
+	_, message, err = ws.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "after pong", string(message))
+
+	// Test: Closing waits for the server to echo the close
+	require.NoError(t, ws.Close(CloseNormalClosure, "bye"))
+	assert.Equal(t, &CloseError{Code: CloseNormalClosure, Text: "bye"}, <-closed)
+
+	// Test: Invalid UTF-8 in a text message fails the connection with 1007
+	raw, _ = handshake(upgradeFields)
+	ws = NewConn(raw, nil, false)
+	require.NoError(t, ws.WriteMessage(TextMessage, []byte{'a', 0xff}))
+	_, _, err = ws.ReadMessage()
+	assert.Equal(t, CloseInvalidPayload, err.(*CloseError).Code)
+	assert.Equal(t, CloseInvalidPayload, (<-closed).(*CloseError).Code)
+
+	// Test: Unmasked frames from the client fail the connection with 1002
+	raw, _ = handshake(upgradeFields)
+	ws = NewConn(raw, nil, false)
+	_, err = raw.Write([]byte{0x81, 0x02, 'h', 'i'})
+	require.NoError(t, err)
+	_, _, err = ws.ReadMessage()
+	assert.Equal(t, CloseProtocolError, err.(*CloseError).Code)
+	<-closed
+
+	// Test: A continuation with no message to continue fails with 1002
+	raw, _ = handshake(upgradeFields)
+	ws = NewConn(raw, nil, false)
+	_, err = raw.Write(maskedFrame(0x80, []byte("orphan")))
+	require.NoError(t, err)
+	_, _, err = ws.ReadMessage()
+	assert.Equal(t, CloseProtocolError, err.(*CloseError).Code)
+	<-closed
+
+	// Test: Fragmented control frames fail with 1002
+	raw, _ = handshake(upgradeFields)
+	ws = NewConn(raw, nil, false)
+	_, err = raw.Write(maskedFrame(0x09, []byte("ping")))
+	require.NoError(t, err)
+	_, _, err = ws.ReadMessage()
+	assert.Equal(t, CloseProtocolError, err.(*CloseError).Code)
+	<-closed
+
+	// Test: Frames sent along with the handshake aren't lost
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	handshakeRequest := fmt.Sprintf("GET /chat HTTP/1.1\r\nHost: %s\r\n%s\r\n", addr, upgradeFields)
+	_, err = conn.Write(append([]byte(handshakeRequest), maskedFrame(0x81, []byte("eager"))...))
+	require.NoError(t, err)
+	reader := response.NewReader(conn)
+	resp, err = reader.ReadHead("GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.Code101, resp.StatusCode)
+	ws = NewConn(conn, reader.TakeBuffered(), false)
+	_, message, err = ws.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "eager", string(message))
+
+	// Test: An unsupported version is answered with 426
+	_, resp = handshake("Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 8\r\nSec-WebSocket-Key: " + testKey + "\r\n")
+	assert.Equal(t, response.Code426, resp.StatusCode)
+	assert.Equal(t, "13", resp.Headers.Get("sec-websocket-version"))
+
+	// Test: A missing key or upgrade is answered with 400
+	_, resp = handshake("Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n")
+	assert.Equal(t, response.Code400, resp.StatusCode)
+	_, resp = handshake("Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + testKey + "\r\n")
+	assert.Equal(t, response.Code400, resp.StatusCode)
+}
+
+// maskedFrame builds a short frame as a client would send it
+func maskedFrame(first byte, payload []byte) []byte {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{first, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	start := len(frame)
+	frame = append(frame, payload...)
+	maskBytes(mask, frame[start:])
+	return frame
+}